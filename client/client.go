@@ -0,0 +1,316 @@
+// Package client is a Go client for the HTTP API of the fs-watcher server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrResyncRequired is returned by Changes when some of the changes asked for
+// are no longer in the journal of the server, the caller has to read every
+// file again.
+var ErrResyncRequired = errors.New("changes no longer in the journal, resync required")
+
+// StatusError is returned when the server answers with an error status.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %d %s", e.Code, http.StatusText(e.Code))
+	}
+	return fmt.Sprintf("server returned %d: %s", e.Code, e.Message)
+}
+
+// File is a file held by the server. Size, ModTime, Mode, Symlink and Hash
+// are only set by All, ReadFile only gets the contents and the metadata. When
+// Symlink is set, Size, ModTime and Mode are those of the target.
+type File struct {
+	Path     string      `json:"path"`
+	Contents string      `json:"contents"`
+	Size     int64       `json:"size,omitempty"`
+	ModTime  time.Time   `json:"mod_time,omitempty"`
+	Mode     fs.FileMode `json:"mode,omitempty"`
+	Symlink  bool        `json:"symlink,omitempty"`
+	Hash     string      `json:"hash,omitempty"`
+	Meta     any         `json:"meta,omitempty"`
+}
+
+// Stat is the stat information of a file, or of a directory. The Size of a
+// directory adds up every file under it, and Files counts them. ModTime, Mode
+// and Hash are only set for files.
+type Stat struct {
+	Path    string    `json:"path"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	Files   int       `json:"files,omitempty"`
+	ModTime time.Time `json:"mod_time,omitempty"`
+	Mode    string    `json:"mode,omitempty"`
+	Symlink bool      `json:"symlink,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+// DirEntry is an entry of a directory returned by ReadDir, with its stat
+// information. Path is relative to the listed directory. Contents and Meta
+// are only set for files.
+type DirEntry struct {
+	Stat
+	Contents string `json:"contents,omitempty"`
+	Meta     any    `json:"meta,omitempty"`
+}
+
+// ReadDirOptions selects the entries listed by ReadDir, the zero value lists
+// the entries of the directory itself by name.
+type ReadDirOptions struct {
+	// Depth is how deep the listing goes: 1, the default, only lists the
+	// directory, -1 lists everything under it.
+	Depth int
+	// Sort is name, mtime, size or meta.<field> for a frontmatter field.
+	Sort string
+	Desc bool
+	// Limit is the number of entries of a page, 0 for no limit.
+	Limit int
+	// Cursor is the cursor returned with the previous page, the other options
+	// must stay the same.
+	Cursor string
+}
+
+// ChangeOp is the kind of a Change.
+type ChangeOp string
+
+const (
+	OpCreated ChangeOp = "created"
+	OpUpdated ChangeOp = "updated"
+	OpRemoved ChangeOp = "removed"
+	OpRenamed ChangeOp = "renamed"
+)
+
+// Change is an entry of the journal of the server. Changes are numbered from 1
+// by Seq, OldPath is only set when a file is renamed.
+type Change struct {
+	Seq     uint64    `json:"seq"`
+	Op      ChangeOp  `json:"op"`
+	Path    string    `json:"path"`
+	OldPath string    `json:"old_path,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	Time    time.Time `json:"time"`
+	Meta    any       `json:"meta,omitempty"`
+}
+
+type Options struct {
+	// Token is sent as a bearer token when the server requires one.
+	Token string
+	// HTTPClient defaults to http.DefaultClient. It must not have a Timeout,
+	// the event stream is a request that never ends: use contexts instead.
+	HTTPClient *http.Client
+}
+
+// Client calls the HTTP API of a server.
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// New returns a client for the server at baseURL, which may include a path
+// prefix the API is mounted under.
+func New(baseURL string, opts Options) *Client {
+	c := &Client{
+		base:  strings.TrimRight(baseURL, "/"),
+		token: opts.Token,
+		http:  opts.HTTPClient,
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	return c
+}
+
+// ReadFile returns the named files, the ones the server doesn't hold are left
+// out.
+func (c *Client) ReadFile(ctx context.Context, paths ...string) ([]File, error) {
+	body, err := json.Marshal(map[string]any{"files": paths})
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	if err := c.do(ctx, http.MethodPost, "/readFile", nil, body, &files); err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return files, nil
+}
+
+// ReadDir returns a page of the entries of dir, and the cursor of the next
+// page if there is one.
+func (c *Client) ReadDir(ctx context.Context, dir string, opts ReadDirOptions) ([]DirEntry, string, error) {
+	query := url.Values{"d": {dir}, "m": {"1"}, "s": {"1"}}
+	if opts.Depth != 0 {
+		query.Set("depth", strconv.Itoa(opts.Depth))
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Desc {
+		query.Set("order", "desc")
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		query.Set("cursor", opts.Cursor)
+	}
+
+	var entries []DirEntry
+	header, err := c.doHeader(ctx, http.MethodGet, "/readdir", query, nil, &entries)
+	if err != nil {
+		return nil, "", fmt.Errorf("read dir: %w", err)
+	}
+	return entries, header.Get("X-Next-Cursor"), nil
+}
+
+// Stat returns the stat information of the named files and directories, the
+// ones the server doesn't hold are left out.
+func (c *Client) Stat(ctx context.Context, paths ...string) ([]Stat, error) {
+	body, err := json.Marshal(map[string]any{"files": paths})
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []Stat
+	if err := c.do(ctx, http.MethodPost, "/stat", nil, body, &stats); err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	return stats, nil
+}
+
+// All returns every file held by the server, by path.
+func (c *Client) All(ctx context.Context) (map[string]File, error) {
+	// the server sends its internal representation, with the absolute path
+	// of the files in Path
+	var all map[string]struct {
+		Rel      string
+		Contents string
+		Size     int64
+		ModTime  time.Time
+		Mode     fs.FileMode
+		Symlink  bool
+		Hash     string
+		Meta     any
+	}
+	if err := c.do(ctx, http.MethodGet, "/all", nil, nil, &all); err != nil {
+		return nil, fmt.Errorf("all: %w", err)
+	}
+
+	files := make(map[string]File, len(all))
+	for k, v := range all {
+		files[k] = File{
+			Path:     v.Rel,
+			Contents: v.Contents,
+			Size:     v.Size,
+			ModTime:  v.ModTime,
+			Mode:     v.Mode,
+			Symlink:  v.Symlink,
+			Hash:     v.Hash,
+			Meta:     v.Meta,
+		}
+	}
+	return files, nil
+}
+
+// Changes returns the changes after the one numbered since, and the sequence
+// number of the last change. It returns ErrResyncRequired when the server no
+// longer has all of them.
+func (c *Client) Changes(ctx context.Context, since uint64) ([]Change, uint64, error) {
+	query := url.Values{"since": {strconv.FormatUint(since, 10)}}
+
+	var resp struct {
+		Seq     uint64   `json:"seq"`
+		Changes []Change `json:"changes"`
+	}
+	err := c.do(ctx, http.MethodGet, "/changes", query, nil, &resp)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusGone {
+		return nil, 0, ErrResyncRequired
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("changes: %w", err)
+	}
+	return resp.Changes, resp.Seq, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Request, error) {
+	target := c.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// send sends the request and returns the response if its status is 2xx.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) != nil {
+		body.Message = strings.TrimSpace(string(data))
+	}
+	return nil, &StatusError{Code: resp.StatusCode, Message: body.Message}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte, v any) error {
+	_, err := c.doHeader(ctx, method, path, query, body, v)
+	return err
+}
+
+// doHeader is do returning the headers of the response.
+func (c *Client) doHeader(ctx context.Context, method, path string, query url.Values, body []byte, v any) (http.Header, error) {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return resp.Header, json.NewDecoder(resp.Body).Decode(v)
+}
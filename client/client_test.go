@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fs-watcher-server/fake"
+	"fs-watcher-server/server"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestServer serves an in-memory root holding files, and lets time pass
+// so that the changes are applied.
+func newTestServer(t *testing.T, files map[string]string, token string) (*fake.FS, *Client) {
+	t.Helper()
+
+	clock := fake.NewClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	source := fake.NewWatcher()
+	fsys := fake.NewFS("/fake/root", source, clock)
+	for name, contents := range files {
+		fsys.WriteFile(name, contents)
+	}
+
+	config := server.DefaultConfig()
+	config.Base = fsys.Dir
+	config.FS = fsys
+	if token != "" {
+		config.AuthTokens = []string{token}
+	}
+
+	s := server.NewFsServer(server.Options{Config: config, Source: source, Clock: clock})
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-s.Ready()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-time.After(time.Millisecond):
+				clock.Advance(config.Debounce)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ts := httptest.NewServer(http.StripPrefix("/fs", s.Handler()))
+	t.Cleanup(func() {
+		close(done)
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+		ts.Close()
+	})
+
+	return fsys, New(ts.URL+"/fs", Options{Token: token})
+}
+
+func TestClient(t *testing.T) {
+	fsys, c := newTestServer(t, map[string]string{
+		"a.md":          "---\ntitle: A\n---\n",
+		"docs/b.md":     "b",
+		"docs/sub/c.md": "c",
+	}, "secret")
+	ctx := context.Background()
+
+	files, err := c.ReadFile(ctx, "a.md", "missing.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "a.md" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	if meta, _ := files[0].Meta.(map[string]any); meta["title"] != "A" {
+		t.Fatalf("unexpected meta: %+v", files[0].Meta)
+	}
+
+	entries, next, err := c.ReadDir(ctx, "docs", ReadDirOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || next != "" || entries[0].Contents != "b" || !entries[1].Dir || entries[1].Files != 1 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	entries, next, err = c.ReadDir(ctx, "docs", ReadDirOptions{Depth: -1, Sort: "name", Desc: true, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || next == "" || entries[0].Path != "sub/c.md" || entries[1].Path != "sub" {
+		t.Fatalf("unexpected first page: %+v", entries)
+	}
+	entries, next, err = c.ReadDir(ctx, "docs", ReadDirOptions{Depth: -1, Sort: "name", Desc: true, Limit: 2, Cursor: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || next != "" || entries[0].Path != "b.md" {
+		t.Fatalf("unexpected last page: %+v", entries)
+	}
+
+	stats, err := c.Stat(ctx, "docs", "docs/b.md", "missing.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || !stats[0].Dir || stats[0].Files != 2 || stats[1].Size != 1 || stats[1].Mode == "" || stats[1].ModTime.IsZero() {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	all, err := c.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := all["docs/sub/c.md"]; len(all) != 3 || f.Path != "docs/sub/c.md" || f.Contents != "c" || f.Size != 1 || f.Hash == "" {
+		t.Fatalf("unexpected file: %+v", f)
+	}
+
+	stream, err := c.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	fsys.WriteFile("d.md", "d")
+	e, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Reset || e.Op != OpCreated || e.Path != "d.md" || e.Seq != 4 {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+
+	changes, seq, err := c.Changes(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 4 || len(changes) != 2 || changes[1].Path != "d.md" {
+		t.Fatalf("unexpected changes: %d %+v", seq, changes)
+	}
+	if _, _, err := c.Changes(ctx, 10); !errors.Is(err, ErrResyncRequired) {
+		t.Fatalf("got %v, want ErrResyncRequired", err)
+	}
+
+	var statusErr *StatusError
+	_, err = New(c.base, Options{}).ReadFile(ctx, "a.md")
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusUnauthorized {
+		t.Fatalf("got %v without a token, want 401", err)
+	}
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Event is a message of the event stream: a change, or a reset when the
+// changes asked for are no longer in the journal of the server. After a reset
+// the client has to read every file again, Seq is the change the stream
+// resumes from.
+type Event struct {
+	Change
+	Reset bool
+}
+
+// EventStream reads the events sent by the server on /events.
+type EventStream struct {
+	body io.ReadCloser
+	r    *bufio.Reader
+}
+
+// Events returns the stream of the changes made from now on.
+func (c *Client) Events(ctx context.Context) (*EventStream, error) {
+	return c.events(ctx, "")
+}
+
+// EventsSince returns the stream of the changes after the one numbered since.
+// The first event is a reset if the server no longer has all of them.
+func (c *Client) EventsSince(ctx context.Context, since uint64) (*EventStream, error) {
+	return c.events(ctx, strconv.FormatUint(since, 10))
+}
+
+func (c *Client) events(ctx context.Context, lastEventID string) (*EventStream, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/events", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	// the server is subscribed by the time it sends the headers
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, fmt.Errorf("events: %w", err)
+	}
+	return &EventStream{body: resp.Body, r: bufio.NewReader(resp.Body)}, nil
+}
+
+// Next blocks until the next event. It returns io.EOF when the server ends the
+// stream, the client can then resume it with EventsSince.
+func (s *EventStream) Next() (Event, error) {
+	var id, typ, data string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				err = io.ErrUnexpectedEOF
+			}
+			return Event{}, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if line == "" {
+			if data == "" {
+				continue
+			}
+			return parseEvent(id, typ, data)
+		}
+		if strings.HasPrefix(line, ":") {
+			// keep-alive
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			typ = value
+		case "data":
+			if data != "" {
+				data += "\n"
+			}
+			data += value
+		}
+	}
+}
+
+func parseEvent(id, typ, data string) (Event, error) {
+	if typ == "reset" {
+		seq, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return Event{}, fmt.Errorf("events: invalid reset id %q", id)
+		}
+		return Event{Change: Change{Seq: seq}, Reset: true}, nil
+	}
+
+	var e Event
+	if err := json.Unmarshal([]byte(data), &e.Change); err != nil {
+		return Event{}, fmt.Errorf("events: %w", err)
+	}
+	return e, nil
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,307 @@
+package client
+
+import (
+	"context"
+	"github.com/labstack/gommon/log"
+	"sync"
+	"time"
+)
+
+const (
+	mirrorBatchSize    = 256
+	mirrorMinRetryWait = 100 * time.Millisecond
+	mirrorMaxRetryWait = 5 * time.Second
+)
+
+// Mirror is a read-only copy of the files held by a server, kept up to date by
+// following the event stream.
+//
+// Files updated by a change are read again with ReadFile, so they have no
+// ModTime until the next full fetch.
+type Mirror struct {
+	client   *Client
+	handlers []func(Change)
+	ready    chan struct{}
+
+	lock  sync.RWMutex
+	files map[string]File
+	seq   uint64
+}
+
+func NewMirror(c *Client) *Mirror {
+	return &Mirror{
+		client: c,
+		ready:  make(chan struct{}),
+		files:  map[string]File{},
+	}
+}
+
+// OnChange registers f to be called, from the goroutine running Run, after a
+// change is applied to the mirror. When the mirror has to fetch every file
+// again, f gets the differences as created, updated and removed changes; the
+// initial fetch doesn't call it. OnChange must be called before Run.
+func (m *Mirror) OnChange(f func(Change)) {
+	m.handlers = append(m.handlers, f)
+}
+
+// Ready is closed once the initial fetch is done.
+func (m *Mirror) Ready() <-chan struct{} {
+	return m.ready
+}
+
+// ReadFile returns the file at path.
+func (m *Mirror) ReadFile(path string) (File, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	f, ok := m.files[path]
+	return f, ok
+}
+
+// Files returns every file of the mirror, by path.
+func (m *Mirror) Files() map[string]File {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	res := make(map[string]File, len(m.files))
+	for k, v := range m.files {
+		res[k] = v
+	}
+	return res
+}
+
+// Seq returns the sequence number of the last change applied.
+func (m *Mirror) Seq() uint64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.seq
+}
+
+// Run fetches every file and applies the changes until ctx is done. When the
+// connection is lost it resumes from the last change applied, or fetches
+// every file again if the server no longer has the changes.
+func (m *Mirror) Run(ctx context.Context) error {
+	wait := mirrorMinRetryWait
+	for {
+		connected, err := m.follow(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if connected {
+			wait = mirrorMinRetryWait
+		}
+		log.Warnf("mirror: %v, retrying in %s", err, wait)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait *= 2
+		if wait > mirrorMaxRetryWait {
+			wait = mirrorMaxRetryWait
+		}
+	}
+}
+
+// follow connects to the event stream and applies the events until the
+// connection is lost.
+func (m *Mirror) follow(ctx context.Context) (connected bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// without the sequence number of a change there is nothing to resume
+	// from: subscribe first, then fetch everything
+	seq := m.Seq()
+	var stream *EventStream
+	if seq > 0 {
+		stream, err = m.client.EventsSince(ctx, seq)
+	} else {
+		stream, err = m.client.Events(ctx)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+
+	events := make(chan Event, mirrorBatchSize)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(events)
+		for {
+			e, err := stream.Next()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if seq == 0 {
+		if err := m.resync(ctx, 0); err != nil {
+			return true, err
+		}
+	}
+
+	for {
+		var batch []Event
+		select {
+		case e, open := <-events:
+			if !open {
+				return true, <-errs
+			}
+			batch = append(batch, e)
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+
+	drain:
+		for len(batch) < mirrorBatchSize {
+			select {
+			case e, open := <-events:
+				if !open {
+					break drain
+				}
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+
+		if err := m.apply(ctx, batch); err != nil {
+			return true, err
+		}
+	}
+}
+
+func (m *Mirror) apply(ctx context.Context, batch []Event) error {
+	for len(batch) > 0 {
+		if batch[0].Reset {
+			if err := m.resync(ctx, batch[0].Seq); err != nil {
+				return err
+			}
+			batch = batch[1:]
+			continue
+		}
+
+		n := 0
+		for n < len(batch) && !batch[n].Reset {
+			n++
+		}
+		changes := make([]Change, n)
+		for i, e := range batch[:n] {
+			changes[i] = e.Change
+		}
+		if err := m.applyChanges(ctx, changes); err != nil {
+			return err
+		}
+		batch = batch[n:]
+	}
+	return nil
+}
+
+// applyChanges reads the created and updated files in one request, then
+// applies the changes in order. The files read may be newer than the
+// changes, the later changes bring the mirror back in line.
+func (m *Mirror) applyChanges(ctx context.Context, changes []Change) error {
+	var paths []string
+	m.lock.RLock()
+	for _, c := range changes {
+		if c.Op == OpRemoved {
+			continue
+		}
+		if _, ok := m.files[c.OldPath]; c.Op == OpRenamed && ok {
+			continue
+		}
+		paths = append(paths, c.Path)
+	}
+	m.lock.RUnlock()
+
+	read := map[string]File{}
+	if len(paths) > 0 {
+		files, err := m.client.ReadFile(ctx, paths...)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			f.Size = int64(len(f.Contents))
+			read[f.Path] = f
+		}
+	}
+
+	m.lock.Lock()
+	for _, c := range changes {
+		if c.Op == OpRenamed {
+			if f, ok := m.files[c.OldPath]; ok {
+				delete(m.files, c.OldPath)
+				f.Path = c.Path
+				m.files[c.Path] = f
+				m.seq = c.Seq
+				continue
+			}
+		}
+
+		if f, ok := read[c.Path]; ok && c.Op != OpRemoved {
+			f.Hash = c.Hash
+			m.files[c.Path] = f
+		} else {
+			delete(m.files, c.Path)
+		}
+		m.seq = c.Seq
+	}
+	m.lock.Unlock()
+
+	for _, c := range changes {
+		m.notify(c)
+	}
+	return nil
+}
+
+// resync replaces the files of the mirror with every file held by the server,
+// seq is the change the server is at.
+func (m *Mirror) resync(ctx context.Context, seq uint64) error {
+	files, err := m.client.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	var changes []Change
+	m.lock.Lock()
+	for path, f := range files {
+		old, ok := m.files[path]
+		if !ok {
+			changes = append(changes, Change{Seq: seq, Op: OpCreated, Path: path, Hash: f.Hash})
+		} else if old.Hash != f.Hash || old.Contents != f.Contents {
+			changes = append(changes, Change{Seq: seq, Op: OpUpdated, Path: path, Hash: f.Hash})
+		}
+	}
+	for path := range m.files {
+		if _, ok := files[path]; !ok {
+			changes = append(changes, Change{Seq: seq, Op: OpRemoved, Path: path})
+		}
+	}
+	m.files = files
+	m.seq = seq
+	m.lock.Unlock()
+
+	select {
+	case <-m.ready:
+		for _, c := range changes {
+			m.notify(c)
+		}
+	default:
+		close(m.ready)
+	}
+	return nil
+}
+
+func (m *Mirror) notify(c Change) {
+	for _, f := range m.handlers {
+		f(c)
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	fsys, c := newTestServer(t, map[string]string{
+		"a.md":      "a",
+		"docs/b.md": "b",
+	}, "")
+
+	m := NewMirror(c)
+	changes := make(chan Change, 16)
+	m.OnChange(func(e Change) {
+		changes <- e
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- m.Run(ctx)
+	}()
+
+	select {
+	case <-m.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("mirror not ready")
+	}
+	if files := m.Files(); len(files) != 2 || files["docs/b.md"].Contents != "b" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case e := <-changes:
+			got := string(e.Op) + " " + e.Path
+			if e.OldPath != "" {
+				got = string(e.Op) + " " + e.OldPath + " -> " + e.Path
+			}
+			if got != want {
+				t.Fatalf("got change %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for change %q", want)
+		}
+	}
+
+	fsys.WriteFile("c.md", "c")
+	expect("created c.md")
+	fsys.WriteFile("a.md", "a2")
+	expect("updated a.md")
+	fsys.Rename("docs", "notes")
+	expect("renamed docs/b.md -> notes/b.md")
+	fsys.Remove("c.md")
+	expect("removed c.md")
+
+	files := m.Files()
+	if len(files) != 2 || files["a.md"].Contents != "a2" || files["notes/b.md"].Contents != "b" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	if m.Seq() != 6 {
+		t.Fatalf("got seq %d, want 6", m.Seq())
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mirror still running after the context was canceled")
+	}
+}
@@ -1,21 +1,171 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"fs-watcher-server/server"
 	"github.com/labstack/gommon/log"
 	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 	cmd := &cobra.Command{
-		Use:   "fs [dir]",
+		Use:   "fs [dir | --mount prefix=dir...]",
 		Short: "Start the fs server",
-		Args:  cobra.ExactArgs(1),
+		Long: "Start the fs server.\n\n" +
+			"Settings are read from the --config file, then from FS_* environment variables " +
+			"(e.g. FS_PORT, FS_JOURNAL_SIZE, FS_EXCLUDE as a comma separated list), " +
+			"and finally from the flags given on the command line.",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+	}
+	def := server.DefaultConfig()
+	flagConfig := cmd.Flags().StringP("config", "c", "", "config file (.yaml, .yml or .toml)")
+	flagMounts := cmd.Flags().StringArrayP("mount", "m", nil, "mount a directory under a prefix, as prefix=dir, instead of serving a single dir")
+	flagBind := cmd.Flags().String("bind", def.Bind, "http bind address")
+	flagHttpPort := cmd.Flags().IntP("port", "p", def.Port, "http port")
+	flagJournalSize := cmd.Flags().Int("journal-size", def.JournalSize, "number of changes kept for /changes and /events")
+	flagSnapshotRetention := cmd.Flags().Duration("snapshot-retention", def.SnapshotRetention, "how long a replaced version of the files can still be read with ?at=")
+	flagRescanInterval := cmd.Flags().Duration("rescan-interval", def.RescanInterval, "interval between full rescans of the directory, 0 to disable")
+	flagDebounce := cmd.Flags().Duration("debounce", def.Debounce, "quiet period after the last watcher event before the changes are applied")
+	flagMaxWait := cmd.Flags().Duration("max-wait", def.MaxWait, "longest delay before the changes are applied while events keep coming")
+	flagWorkers := cmd.Flags().Int("workers", def.Workers, "number of files read in parallel, by the initial scan and the updates")
+	flagReadyWait := cmd.Flags().Duration("ready-wait", def.ReadyWait, "how long requests made during the initial scan wait for it before getting a 503")
+	flagShutdownTimeout := cmd.Flags().Duration("shutdown-timeout", def.ShutdownTimeout, "how long open requests are drained on SIGINT or SIGTERM")
+	flagWatcher := cmd.Flags().String("watcher", def.Watcher, "watcher backend: fsnotify, or poll for file systems without change notifications")
+	flagPollInterval := cmd.Flags().Duration("poll-interval", def.PollInterval, "interval between scans of the poll watcher")
+	flagInclude := cmd.Flags().StringSlice("include", def.Include, "only load files matching these glob patterns (doublestar syntax, relative to dir)")
+	flagExclude := cmd.Flags().StringSlice("exclude", def.Exclude, "skip files and directories matching these glob patterns (doublestar syntax, relative to dir)")
+	flagGitIgnore := cmd.Flags().Bool("gitignore", def.GitIgnore, "honour .gitignore and .ignore files found in the tree")
+	flagFrontmatter := cmd.Flags().StringSlice("frontmatter-ext", def.FrontmatterExtensions, "extensions of the files whose frontmatter is parsed")
+	flagCorsOrigins := cmd.Flags().StringSlice("cors-origin", def.CorsOrigins, "origins allowed to make cross-origin requests")
+	flagAuthTokens := cmd.Flags().StringSlice("auth-token", def.AuthTokens, "bearer tokens accepted by the server, no authentication if empty")
+
+	applyFlags := func(cmd *cobra.Command, args []string, config *server.Config) error {
+		flags := cmd.Flags()
+		if len(args) > 0 {
+			config.Base = args[0]
+		}
+		if flags.Changed("mount") {
+			config.Roots = nil
+			for _, v := range *flagMounts {
+				m, err := server.ParseMount(v)
+				if err != nil {
+					return err
+				}
+				config.Roots = append(config.Roots, m)
+			}
+		}
+		if flags.Changed("bind") {
+			config.Bind = *flagBind
+		}
+		if flags.Changed("port") {
+			config.Port = *flagHttpPort
+		}
+		if flags.Changed("journal-size") {
+			config.JournalSize = *flagJournalSize
+		}
+		if flags.Changed("snapshot-retention") {
+			config.SnapshotRetention = *flagSnapshotRetention
+		}
+		if flags.Changed("rescan-interval") {
+			config.RescanInterval = *flagRescanInterval
+		}
+		if flags.Changed("debounce") {
+			config.Debounce = *flagDebounce
+		}
+		if flags.Changed("max-wait") {
+			config.MaxWait = *flagMaxWait
+		}
+		if flags.Changed("workers") {
+			config.Workers = *flagWorkers
+		}
+		if flags.Changed("ready-wait") {
+			config.ReadyWait = *flagReadyWait
+		}
+		if flags.Changed("shutdown-timeout") {
+			config.ShutdownTimeout = *flagShutdownTimeout
+		}
+		if flags.Changed("watcher") {
+			config.Watcher = *flagWatcher
+		}
+		if flags.Changed("poll-interval") {
+			config.PollInterval = *flagPollInterval
+		}
+		if flags.Changed("include") {
+			config.Include = *flagInclude
+		}
+		if flags.Changed("exclude") {
+			config.Exclude = *flagExclude
+		}
+		if flags.Changed("gitignore") {
+			config.GitIgnore = *flagGitIgnore
+		}
+		if flags.Changed("frontmatter-ext") {
+			config.FrontmatterExtensions = *flagFrontmatter
+		}
+		if flags.Changed("cors-origin") {
+			config.CorsOrigins = *flagCorsOrigins
+		}
+		if flags.Changed("auth-token") {
+			config.AuthTokens = *flagAuthTokens
+		}
+		return nil
 	}
-	flagHttpPort := cmd.Flags().IntP("port", "p", 8090, "http port")
 
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
-		s := NewFsServer(args[0], *flagHttpPort)
-		return s.Start()
+		loadConfig := func() (server.Config, error) {
+			config := server.DefaultConfig()
+			if *flagConfig != "" {
+				if err := config.LoadConfigFile(*flagConfig); err != nil {
+					return config, err
+				}
+			}
+			if err := config.LoadEnv(); err != nil {
+				return config, err
+			}
+			return config, applyFlags(cmd, args, &config)
+		}
+
+		config, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if err = config.Validate(); err != nil {
+			return err
+		}
+
+		s := server.NewFsServer(server.Options{
+			Config:     config,
+			ConfigFile: *flagConfig,
+			LoadConfig: loadConfig,
+		})
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- s.Start()
+		}()
+		select {
+		case err = <-errs:
+			return err
+		case <-ctx.Done():
+		}
+
+		// a second signal kills the process
+		stop()
+		log.Infof("shutting down, draining requests for up to %v", config.ShutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		if err = s.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("shutdown: %w", err)
+		}
+		return <-errs
 	}
 
 	if err := cmd.Execute(); err != nil {
@@ -0,0 +1,118 @@
+package fake
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a clock that only moves when Advance is called, so that tests
+// decide when timers and tickers fire.
+type Clock struct {
+	lock    sync.Mutex
+	now     time.Time
+	tickers []*ticker
+}
+
+// ticker is a ticker, or a timer when period is 0.
+type ticker struct {
+	c       chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+// NewClock returns a clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// NewTicker is like time.NewTicker: the channel has a buffer of one tick and
+// ticks are dropped if the receiver falls behind.
+func (c *Clock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &ticker{
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   c.now.Add(d),
+	}
+	c.tickers = append(c.tickers, t)
+	return t.c, func() {
+		c.lock.Lock()
+		t.stopped = true
+		c.lock.Unlock()
+	}
+}
+
+// NewTimer is like time.NewTimer.
+func (c *Clock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &ticker{
+		c:    make(chan time.Time, 1),
+		next: c.now.Add(d),
+	}
+	c.tickers = append(c.tickers, t)
+	return t.c, func() bool {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		active := !t.stopped
+		t.stopped = true
+		return active
+	}
+}
+
+// Waiters returns the number of timers and tickers that are still running.
+func (c *Clock) Waiters() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n := 0
+	for _, t := range c.tickers {
+		if !t.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+// Advance moves the clock forward by d, firing the tickers that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	active := c.tickers[:0]
+	for _, t := range c.tickers {
+		if t.stopped {
+			continue
+		}
+		if !t.next.After(c.now) {
+			select {
+			case t.c <- c.now:
+			default:
+			}
+			if t.period == 0 {
+				t.stopped = true
+				continue
+			}
+			for !t.next.After(c.now) {
+				t.next = t.next.Add(t.period)
+			}
+		}
+		active = append(active, t)
+	}
+	c.tickers = active
+}
@@ -0,0 +1,195 @@
+package fake
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// FS is an in-memory file system that can be modified while it is read. Every
+// change is reported to the watcher, if any, as the events the OS would send
+// for the directory Dir that the FS stands for.
+//
+// Names passed to the methods are slash-separated and relative to the root,
+// like for any fs.FS.
+type FS struct {
+	Dir string
+
+	watcher *Watcher
+	clock   *Clock
+
+	lock  sync.RWMutex
+	files fstest.MapFS
+}
+
+// NewFS returns an empty FS for dir. watcher and clock may be nil, the
+// modification time of the files is taken from clock.
+func NewFS(dir string, watcher *Watcher, clock *Clock) *FS {
+	return &FS{
+		Dir:     filepath.Clean(dir),
+		watcher: watcher,
+		clock:   clock,
+		files:   fstest.MapFS{},
+	}
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.files.Open(name)
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.files.Stat(name)
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.files.ReadFile(name)
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.files.ReadDir(name)
+}
+
+// Path returns the OS name of a file of the FS, as reported by the watcher.
+func (f *FS) Path(name string) string {
+	return filepath.Join(f.Dir, filepath.FromSlash(name))
+}
+
+// WriteFile creates or replaces a file, creating its parent directories.
+func (f *FS) WriteFile(name, contents string) {
+	f.lock.Lock()
+	created := f.mkdirAll(path.Dir(name))
+	op := fsnotify.Create
+	if _, ok := f.files[name]; ok {
+		op = fsnotify.Write
+	}
+	f.files[name] = &fstest.MapFile{
+		Data:    []byte(contents),
+		Mode:    0644,
+		ModTime: f.now(),
+	}
+	f.lock.Unlock()
+
+	for _, d := range created {
+		f.emit(d, fsnotify.Create)
+	}
+	f.emit(name, op)
+}
+
+// Mkdir creates a directory and its parents.
+func (f *FS) Mkdir(name string) {
+	f.lock.Lock()
+	created := f.mkdirAll(name)
+	f.lock.Unlock()
+
+	for _, d := range created {
+		f.emit(d, fsnotify.Create)
+	}
+}
+
+// Remove removes a file or a directory with everything under it.
+func (f *FS) Remove(name string) {
+	f.lock.Lock()
+	removed := f.under(name)
+	for _, p := range removed {
+		delete(f.files, p)
+	}
+	f.lock.Unlock()
+
+	// the contents go before their directory
+	for i := len(removed) - 1; i >= 0; i-- {
+		f.emit(removed[i], fsnotify.Remove)
+	}
+}
+
+// Rename moves a file or a directory with everything under it. A directory
+// reports its contents as created after the rename, like watcher.RWatcher.
+func (f *FS) Rename(oldName, newName string) {
+	f.lock.Lock()
+	created := f.mkdirAll(path.Dir(newName))
+	moved := f.under(oldName)
+	for _, p := range moved {
+		if file, ok := f.files[p]; ok {
+			f.files[newName+strings.TrimPrefix(p, oldName)] = file
+			delete(f.files, p)
+		}
+	}
+	f.lock.Unlock()
+
+	for _, d := range created {
+		f.emit(d, fsnotify.Create)
+	}
+	if len(moved) == 0 {
+		return
+	}
+	f.emit(oldName, fsnotify.Rename)
+	f.emit(newName, fsnotify.Create)
+	for _, p := range moved {
+		if p != oldName {
+			f.emit(newName+strings.TrimPrefix(p, oldName), fsnotify.Create)
+		}
+	}
+}
+
+// mkdirAll adds the missing directories of name and returns them, parents
+// first. It must be called with the lock held.
+func (f *FS) mkdirAll(name string) []string {
+	if name == "." || name == "" {
+		return nil
+	}
+	if _, err := f.files.Stat(name); err == nil {
+		return nil
+	}
+	created := f.mkdirAll(path.Dir(name))
+	f.files[name] = &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: f.now()}
+	return append(created, name)
+}
+
+// under returns the sorted names of name and everything under it, including
+// the directories that only exist implicitly. It must be called with the lock
+// held.
+func (f *FS) under(name string) []string {
+	set := map[string]struct{}{}
+	for p := range f.files {
+		if p != name && !strings.HasPrefix(p, name+"/") {
+			continue
+		}
+		for ; p != name && p != "."; p = path.Dir(p) {
+			set[p] = struct{}{}
+		}
+		set[name] = struct{}{}
+	}
+
+	res := make([]string, 0, len(set))
+	for p := range set {
+		res = append(res, p)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (f *FS) now() time.Time {
+	if f.clock == nil {
+		return time.Time{}
+	}
+	return f.clock.Now()
+}
+
+func (f *FS) emit(name string, op fsnotify.Op) {
+	if f.watcher != nil {
+		f.watcher.Emit(f.Path(name), op)
+	}
+}
@@ -0,0 +1,144 @@
+package fake
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrClosed = errors.New("fake watcher already closed")
+
+// Watcher is an in-memory event source with the semantics of
+// watcher.RWatcher. Events are delivered on unbuffered channels, so when Emit
+// returns the receiver has already got the event.
+type Watcher struct {
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+
+	lock      sync.Mutex
+	isClosed  bool
+	dropping  bool
+	watched   map[string]struct{}
+	recursive map[string]struct{}
+}
+
+func NewWatcher() *Watcher {
+	return &Watcher{
+		events:    make(chan fsnotify.Event),
+		errors:    make(chan error),
+		done:      make(chan struct{}),
+		watched:   map[string]struct{}{},
+		recursive: map[string]struct{}{},
+	}
+}
+
+// Add watches the named file or directory, without its sub-directories.
+func (w *Watcher) Add(name string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.isClosed {
+		return ErrClosed
+	}
+	w.watched[filepath.Clean(name)] = struct{}{}
+	return nil
+}
+
+// AddRecursive watches the named directory and everything under it.
+func (w *Watcher) AddRecursive(name string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.isClosed {
+		return ErrClosed
+	}
+	name = filepath.Clean(name)
+	w.watched[name] = struct{}{}
+	w.recursive[name] = struct{}{}
+	return nil
+}
+
+func (w *Watcher) Events() <-chan fsnotify.Event {
+	return w.events
+}
+
+func (w *Watcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *Watcher) WatchList() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	res := make([]string, 0, len(w.watched))
+	for n := range w.watched {
+		res = append(res, n)
+	}
+	return res
+}
+
+func (w *Watcher) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.isClosed {
+		return nil
+	}
+	w.isClosed = true
+	close(w.done)
+	return nil
+}
+
+// Drop makes the watcher lose every event until it is called with false, like
+// an overflowing kernel queue.
+func (w *Watcher) Drop(drop bool) {
+	w.lock.Lock()
+	w.dropping = drop
+	w.lock.Unlock()
+}
+
+// Watches reports whether an event for name would be delivered.
+func (w *Watcher) Watches(name string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.dropping {
+		return false
+	}
+	name = filepath.Clean(name)
+	if _, ok := w.watched[name]; ok {
+		return true
+	}
+	if _, ok := w.watched[filepath.Dir(name)]; ok {
+		return true
+	}
+	for r := range w.recursive {
+		if strings.HasPrefix(name, r+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit delivers an event if its name is watched, blocking until it is
+// received or the watcher is closed.
+func (w *Watcher) Emit(name string, op fsnotify.Op) {
+	if !w.Watches(name) {
+		return
+	}
+	select {
+	case w.events <- fsnotify.Event{Name: name, Op: op}:
+	case <-w.done:
+	}
+}
+
+// EmitError delivers an error, like fsnotify.ErrEventOverflow.
+func (w *Watcher) EmitError(err error) {
+	select {
+	case w.errors <- err:
+	case <-w.done:
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// ErrResyncRequired is returned by Subscribe when some of the changes asked
+// for are no longer in the journal, the caller has to read every file again.
+var ErrResyncRequired = errors.New("changes no longer in the journal, resync required")
+
+// File is a file held by the server. When Symlink is set, Size, ModTime and
+// Mode are those of the target.
+type File struct {
+	// Path is the path of the file in the served namespace, relative to its
+	// root and under the root prefix.
+	Path     string
+	Contents string
+	Size     int64
+	ModTime  time.Time
+	Mode     fs.FileMode
+	Symlink  bool
+	Hash     string
+	Meta     any
+}
+
+func (d fsFileData) file() File {
+	return File{
+		Path:     d.Rel,
+		Contents: d.Contents,
+		Size:     d.Size,
+		ModTime:  d.ModTime,
+		Mode:     d.Mode,
+		Symlink:  d.Symlink,
+		Hash:     d.Hash,
+		Meta:     d.Meta,
+	}
+}
+
+// Handler returns the HTTP API of the server. To mount it under a prefix,
+// strip the prefix first:
+//
+//	mux.Handle("/fs/", http.StripPrefix("/fs", s.Handler()))
+func (s *FsServer) Handler() http.Handler {
+	return s.web
+}
+
+// Ready is closed once the initial scan started by Init is done.
+func (s *FsServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// ReadFile returns the file at path in the served namespace.
+func (s *FsServer) ReadFile(path string) (File, bool) {
+	d, ok := s.loadedFiles.TryGet(path)
+	if !ok {
+		return File{}, false
+	}
+	return d.file(), true
+}
+
+// Files returns every file held by the server, by path.
+func (s *FsServer) Files() map[string]File {
+	files := s.loadedFiles.Copy()
+	res := make(map[string]File, len(files))
+	for k, v := range files {
+		res[k] = v.file()
+	}
+	return res
+}
+
+// Seq returns the sequence number of the last change.
+func (s *FsServer) Seq() uint64 {
+	seq, _ := s.changes.stats()
+	return seq
+}
+
+// Subscribe returns the changes after the one numbered since, followed by
+// every new change. The channel is closed when ctx is done, when the server
+// shuts down, or when the subscriber falls behind; the caller can then
+// subscribe again from the last change it received. Pass Seq() as since to
+// only get the new changes.
+func (s *FsServer) Subscribe(ctx context.Context, since uint64) (<-chan Change, error) {
+	ch, missed, _, ok := s.changes.subscribe(since, true)
+	if !ok {
+		s.changes.unsubscribe(ch)
+		return nil, ErrResyncRequired
+	}
+
+	out := make(chan Change)
+	go func() {
+		defer close(out)
+		defer s.changes.unsubscribe(ch)
+
+		send := func(e Change) bool {
+			select {
+			case out <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			case <-s.closing:
+				return false
+			}
+		}
+		for _, e := range missed {
+			if !send(e) {
+				return
+			}
+		}
+		for {
+			select {
+			case e, open := <-ch:
+				if !open || !send(e) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-s.closing:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
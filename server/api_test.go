@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLibraryAPI(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"a.md":    "---\ntitle: A\n---\n",
+		"b/c.txt": "c",
+	})
+
+	f, ok := ts.ReadFile("a.md")
+	if !ok || f.Path != "a.md" || f.Size != int64(len("---\ntitle: A\n---\n")) {
+		t.Fatalf("unexpected file: %+v", f)
+	}
+	if meta, _ := f.Meta.(map[string]any); meta["title"] != "A" {
+		t.Fatalf("unexpected meta: %+v", f.Meta)
+	}
+	if _, ok := ts.ReadFile("missing.md"); ok {
+		t.Fatal("got a missing file")
+	}
+	if files := ts.Files(); len(files) != 2 || files["b/c.txt"].Contents != "c" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+
+	// the changes after since come first, then the new ones
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := ts.Subscribe(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.fs.WriteFile("d.md", "d")
+	ts.expectChanges(t, "created d.md")
+
+	for _, want := range []uint64{2, 3} {
+		select {
+		case e := <-changes:
+			if e.Seq != want {
+				t.Fatalf("got change %d, want %d", e.Seq, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for change %d", want)
+		}
+	}
+	if ts.Seq() != 3 {
+		t.Fatalf("got seq %d, want 3", ts.Seq())
+	}
+
+	cancel()
+	select {
+	case _, open := <-changes:
+		if open {
+			t.Fatal("got a change after the context was canceled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after the context was canceled")
+	}
+}
+
+func TestHandlerPrefix(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"a.md": "a"})
+
+	mux := http.NewServeMux()
+	mux.Handle("/fs/", http.StripPrefix("/fs", ts.Handler()))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fs/readFile?f=a.md", nil))
+	var files []readFileEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &files); err != nil {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(files) != 1 || files[0].Contents != "a" {
+		t.Fatalf("unexpected response: %+v", files)
+	}
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/bmatcuk/doublestar/v4"
+	"gopkg.in/yaml.v2"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "FS_"
+
+const (
+	watcherFsnotify = "fsnotify"
+	watcherPoll     = "poll"
+)
+
+// Config holds every setting of FsServer. It can be read from a YAML or TOML
+// file, and each field can be overridden by the FS_<NAME> environment variable
+// named after its yaml key. Roots are set with FS_MOUNTS as a comma separated
+// list of prefix=dir.
+type Config struct {
+	Base                  string        `yaml:"dir" toml:"dir"`
+	Roots                 []RootConfig  `yaml:"roots" toml:"roots"`
+	Bind                  string        `yaml:"bind" toml:"bind"`
+	Port                  int           `yaml:"port" toml:"port"`
+	JournalSize           int           `yaml:"journal_size" toml:"journal_size"`
+	SnapshotRetention     time.Duration `yaml:"snapshot_retention" toml:"snapshot_retention"`
+	RescanInterval        time.Duration `yaml:"rescan_interval" toml:"rescan_interval"`
+	Debounce              time.Duration `yaml:"debounce" toml:"debounce"`
+	MaxWait               time.Duration `yaml:"max_wait" toml:"max_wait"`
+	Workers               int           `yaml:"workers" toml:"workers"`
+	ReadyWait             time.Duration `yaml:"ready_wait" toml:"ready_wait"`
+	ShutdownTimeout       time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	Watcher               string        `yaml:"watcher" toml:"watcher"`
+	PollInterval          time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	Include               []string      `yaml:"include" toml:"include"`
+	Exclude               []string      `yaml:"exclude" toml:"exclude"`
+	GitIgnore             bool          `yaml:"gitignore" toml:"gitignore"`
+	FrontmatterExtensions []string      `yaml:"frontmatter_extensions" toml:"frontmatter_extensions"`
+	CorsOrigins           []string      `yaml:"cors_origins" toml:"cors_origins"`
+	AuthTokens            []string      `yaml:"auth_tokens" toml:"auth_tokens"`
+
+	// FS is read instead of Base, see RootConfig.FS.
+	FS fs.FS `yaml:"-" toml:"-"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Port:                  8090,
+		JournalSize:           defaultJournalSize,
+		SnapshotRetention:     time.Minute,
+		Debounce:              100 * time.Millisecond,
+		MaxWait:               time.Second,
+		Workers:               runtime.NumCPU(),
+		ShutdownTimeout:       10 * time.Second,
+		Watcher:               watcherFsnotify,
+		PollInterval:          2 * time.Second,
+		Exclude:               defaultExclude,
+		FrontmatterExtensions: []string{".md", ".mdx"},
+	}
+}
+
+// LoadConfigFile reads a YAML or TOML file, depending on its extension, on top
+// of the values already in c.
+func (c *Config) LoadConfigFile(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, c)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), c)
+		if err == nil {
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				err = fmt.Errorf("unknown key %q", undecoded[0].String())
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unknown config format, expected .yaml, .yml or .toml", fileName)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+	return nil
+}
+
+// LoadEnv applies the FS_* environment variables.
+func (c *Config) LoadEnv() error {
+	vars := map[string]func(v string) error{
+		"DIR":                    setString(&c.Base),
+		"MOUNTS":                 setMounts(&c.Roots),
+		"BIND":                   setString(&c.Bind),
+		"PORT":                   setInt(&c.Port),
+		"JOURNAL_SIZE":           setInt(&c.JournalSize),
+		"SNAPSHOT_RETENTION":     setDuration(&c.SnapshotRetention),
+		"RESCAN_INTERVAL":        setDuration(&c.RescanInterval),
+		"DEBOUNCE":               setDuration(&c.Debounce),
+		"MAX_WAIT":               setDuration(&c.MaxWait),
+		"WORKERS":                setInt(&c.Workers),
+		"READY_WAIT":             setDuration(&c.ReadyWait),
+		"SHUTDOWN_TIMEOUT":       setDuration(&c.ShutdownTimeout),
+		"WATCHER":                setString(&c.Watcher),
+		"POLL_INTERVAL":          setDuration(&c.PollInterval),
+		"INCLUDE":                setList(&c.Include),
+		"EXCLUDE":                setList(&c.Exclude),
+		"GITIGNORE":              setBool(&c.GitIgnore),
+		"FRONTMATTER_EXTENSIONS": setList(&c.FrontmatterExtensions),
+		"CORS_ORIGINS":           setList(&c.CorsOrigins),
+		"AUTH_TOKENS":            setList(&c.AuthTokens),
+	}
+	for name, set := range vars {
+		v, ok := os.LookupEnv(envPrefix + name)
+		if !ok {
+			continue
+		}
+		if err := set(v); err != nil {
+			return fmt.Errorf("%s%s: %w", envPrefix, name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.Base == "" && len(c.Roots) == 0 {
+		return fmt.Errorf("dir: missing")
+	}
+	if c.Base != "" && len(c.Roots) > 0 {
+		return fmt.Errorf("dir: cannot be used together with roots")
+	}
+	if err := validateMounts(c.mounts()); err != nil {
+		return err
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("port: %d is out of range", c.Port)
+	}
+	if c.JournalSize <= 0 {
+		return fmt.Errorf("journal_size: must be positive, got %d", c.JournalSize)
+	}
+	if c.SnapshotRetention < 0 {
+		return fmt.Errorf("snapshot_retention: must not be negative, got %v", c.SnapshotRetention)
+	}
+	if c.RescanInterval < 0 {
+		return fmt.Errorf("rescan_interval: must not be negative, got %v", c.RescanInterval)
+	}
+	if c.Debounce <= 0 {
+		return fmt.Errorf("debounce: must be positive, got %v", c.Debounce)
+	}
+	if c.MaxWait < c.Debounce {
+		return fmt.Errorf("max_wait: must not be shorter than debounce (%v), got %v", c.Debounce, c.MaxWait)
+	}
+	if c.Workers <= 0 {
+		return fmt.Errorf("workers: must be positive, got %d", c.Workers)
+	}
+	if c.ReadyWait < 0 {
+		return fmt.Errorf("ready_wait: must not be negative, got %v", c.ReadyWait)
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown_timeout: must be positive, got %v", c.ShutdownTimeout)
+	}
+	switch c.Watcher {
+	case watcherFsnotify:
+	case watcherPoll:
+		if c.PollInterval <= 0 {
+			return fmt.Errorf("poll_interval: must be positive, got %v", c.PollInterval)
+		}
+	default:
+		return fmt.Errorf("watcher: unknown backend %q, expected %s or %s", c.Watcher, watcherFsnotify, watcherPoll)
+	}
+	if err := validateRules(c.Include, c.Exclude, c.FrontmatterExtensions); err != nil {
+		return err
+	}
+	for _, t := range c.AuthTokens {
+		if t == "" {
+			return fmt.Errorf("auth_tokens: empty token")
+		}
+	}
+	return nil
+}
+
+func validateMounts(mounts []RootConfig) error {
+	for i, m := range mounts {
+		name := "dir"
+		if len(mounts) > 1 || m.Prefix != "" {
+			name = fmt.Sprintf("roots[%d]", i)
+		}
+
+		if m.Dir == "" {
+			return fmt.Errorf("%s: missing dir", name)
+		}
+		if m.FS == nil {
+			if stat, err := os.Stat(m.Dir); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			} else if !stat.IsDir() {
+				return fmt.Errorf("%s: %s is not a directory", name, m.Dir)
+			}
+		}
+
+		abs, err := filepath.Abs(m.Dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for j, o := range mounts[:i] {
+			// a prefix inside another would give the files of both roots
+			// the same keys
+			switch {
+			case o.Prefix == m.Prefix:
+				return fmt.Errorf("%s: prefix %q is mounted twice", name, m.Prefix)
+			case strings.HasPrefix(m.Prefix, o.Prefix):
+				return fmt.Errorf("%s: prefix %q is inside the prefix %q of roots[%d]", name, m.Prefix, o.Prefix, j)
+			case strings.HasPrefix(o.Prefix, m.Prefix):
+				return fmt.Errorf("%s: prefix %q of roots[%d] is inside the prefix %q", name, o.Prefix, j, m.Prefix)
+			}
+
+			other, err := filepath.Abs(o.Dir)
+			if err != nil {
+				continue
+			}
+			if rel, err := filepath.Rel(other, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("%s: %s is inside roots[%d]", name, m.Dir, j)
+			}
+			if rel, err := filepath.Rel(abs, other); err == nil && !strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("%s: roots[%d] is inside %s", name, j, m.Dir)
+			}
+		}
+
+		if err := validateRules(m.Include, m.Exclude, m.FrontmatterExtensions); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func validateRules(include, exclude, frontmatter []string) error {
+	for _, p := range include {
+		if !doublestar.ValidatePattern(p) {
+			return fmt.Errorf("include: invalid pattern %q", p)
+		}
+	}
+	for _, p := range exclude {
+		if !doublestar.ValidatePattern(p) {
+			return fmt.Errorf("exclude: invalid pattern %q", p)
+		}
+	}
+	for _, ext := range frontmatter {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("frontmatter_extensions: %q must start with a dot", ext)
+		}
+	}
+	return nil
+}
+
+// ParseMount parses a prefix=dir mount, as given to --mount and FS_MOUNTS.
+func ParseMount(v string) (RootConfig, error) {
+	i := strings.IndexByte(v, '=')
+	if i < 0 {
+		return RootConfig{}, fmt.Errorf("invalid mount %q, expected prefix=dir", v)
+	}
+	return RootConfig{Prefix: v[:i], Dir: v[i+1:]}, nil
+}
+
+func setString(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(v string) (err error) {
+		*p, err = strconv.Atoi(v)
+		return
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(v string) (err error) {
+		*p, err = strconv.ParseBool(v)
+		return
+	}
+}
+
+func setDuration(p *time.Duration) func(string) error {
+	return func(v string) (err error) {
+		*p, err = time.ParseDuration(v)
+		return
+	}
+}
+
+func setList(p *[]string) func(string) error {
+	return func(v string) error {
+		*p = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*p = append(*p, s)
+			}
+		}
+		return nil
+	}
+}
+
+func setMounts(p *[]RootConfig) func(string) error {
+	return func(v string) error {
+		*p = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			m, err := ParseMount(s)
+			if err != nil {
+				return err
+			}
+			*p = append(*p, m)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidateRoots(t *testing.T) {
+	tests := []struct {
+		prefixes []string
+		err      string
+	}{
+		{[]string{"guides", "api"}, ""},
+		{[]string{"guides", "guides-old"}, ""},
+		{[]string{"guides", "/guides/"}, "mounted twice"},
+		{[]string{"guides", "guides/api"}, `prefix "guides/api/" is inside the prefix "guides/"`},
+		{[]string{"guides/api", "guides"}, `prefix "guides/api/" of roots[0] is inside the prefix "guides/"`},
+		{[]string{"", "x"}, `prefix "x/" is inside the prefix ""`},
+	}
+	for _, test := range tests {
+		config := DefaultConfig()
+		for i, p := range test.prefixes {
+			config.Roots = append(config.Roots, RootConfig{
+				Prefix: p,
+				Dir:    "/fake/root" + strings.Repeat("x", i),
+				FS:     fstest.MapFS{},
+			})
+		}
+		err := config.Validate()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%q: got %v, want %q", test.prefixes, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"time"
+)
+
+// debouncer decides when the pending events are applied: once no event came
+// for quiet, or maxWait after the first pending event when they keep coming.
+type debouncer struct {
+	quiet   time.Duration
+	maxWait time.Duration
+
+	pending bool
+	first   time.Time
+	last    time.Time
+}
+
+func (d *debouncer) add(now time.Time) {
+	if !d.pending {
+		d.pending = true
+		d.first = now
+	}
+	d.last = now
+}
+
+// wait returns how long until the pending events are due, zero or less if
+// they are.
+func (d *debouncer) wait(now time.Time) time.Duration {
+	due := d.last.Add(d.quiet)
+	if limit := d.first.Add(d.maxWait); limit.Before(due) {
+		due = limit
+	}
+	return due.Sub(now)
+}
+
+func (d *debouncer) reset() {
+	d.pending = false
+}
+
+// eventQueue holds the events of a batch in order, dropping the ones that
+// would not change the outcome. Every event is applied by reading the file
+// again, so a Write is redundant after a Create or a Write of the same path
+// that is still queued. Creates are kept, since the one following a Rename
+// pairs with it.
+type eventQueue struct {
+	events   []fsnotify.Event
+	received []time.Time
+	last     map[string]fsnotify.Op
+}
+
+// add queues an event received at the given time.
+func (q *eventQueue) add(e fsnotify.Event, received time.Time) {
+	if e.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) == 0 {
+		// chmod alone never changes the contents
+		return
+	}
+	if q.last == nil {
+		q.last = map[string]fsnotify.Op{}
+	}
+	if e.Op == fsnotify.Write && q.last[e.Name]&(fsnotify.Create|fsnotify.Write) != 0 {
+		return
+	}
+	q.events = append(q.events, e)
+	q.received = append(q.received, received)
+	q.last[e.Name] = e.Op
+}
+
+// take returns the queued events with the time they were received, and
+// empties the queue.
+func (q *eventQueue) take() ([]fsnotify.Event, []time.Time) {
+	events, received := q.events, q.received
+	q.events, q.received, q.last = nil, nil, nil
+	return events, received
+}
+
+// eventBatch is the work handed to the update worker.
+type eventBatch struct {
+	events   []fsnotify.Event
+	received []time.Time
+	reload   bool
+}
+
+// merge appends a batch that could not be handed over yet, the worker still
+// applies everything in order.
+func (b *eventBatch) merge(o eventBatch) {
+	b.events = append(b.events, o.events...)
+	b.received = append(b.received, o.received...)
+	b.reload = b.reload || o.reload
+}
+
+// applyBatches is the update worker: it applies the batches one at a time, so
+// the changes of a path are never applied out of order. It starts after the
+// initial scan.
+func (s *FsServer) applyBatches(batches <-chan eventBatch) {
+	select {
+	case <-s.ready:
+	case <-s.done:
+		return
+	}
+
+	for b := range batches {
+		s.updateLock.Lock()
+		s.applyEvents(b.events)
+		s.updateLock.Unlock()
+		s.observeLatency(b.received)
+		if b.reload {
+			s.reloadConfigLogged()
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := debouncer{quiet: 100 * time.Millisecond, maxWait: time.Second}
+
+	d.add(start)
+	if w := d.wait(start); w != 100*time.Millisecond {
+		t.Fatalf("wait after the first event: %v", w)
+	}
+
+	// every event restarts the quiet period
+	now := start
+	for i := 0; i < 10; i++ {
+		now = now.Add(90 * time.Millisecond)
+		d.add(now)
+	}
+	if w := d.wait(now); w != 100*time.Millisecond {
+		t.Fatalf("wait after a burst: %v", w)
+	}
+
+	// but not past the max wait
+	now = now.Add(90 * time.Millisecond)
+	d.add(now)
+	if w := d.wait(now); w != 10*time.Millisecond {
+		t.Fatalf("wait near the max wait: %v", w)
+	}
+	if w := d.wait(start.Add(time.Second)); w > 0 {
+		t.Fatalf("events not due after the max wait: %v", w)
+	}
+
+	d.reset()
+	now = now.Add(time.Second)
+	d.add(now)
+	if w := d.wait(now); w != 100*time.Millisecond {
+		t.Fatalf("wait after reset: %v", w)
+	}
+}
+
+func TestEventQueue(t *testing.T) {
+	var q eventQueue
+	for _, e := range []fsnotify.Event{
+		{Name: "a", Op: fsnotify.Write},
+		{Name: "a", Op: fsnotify.Write},
+		{Name: "a", Op: fsnotify.Chmod},
+		{Name: "b", Op: fsnotify.Create},
+		{Name: "b", Op: fsnotify.Write},
+		{Name: "a", Op: fsnotify.Rename},
+		{Name: "b", Op: fsnotify.Create},
+		{Name: "b", Op: fsnotify.Write},
+		{Name: "a", Op: fsnotify.Write},
+		{Name: "a", Op: fsnotify.Remove},
+		{Name: "a", Op: fsnotify.Write},
+	} {
+		q.add(e, time.Time{})
+	}
+
+	want := []fsnotify.Event{
+		{Name: "a", Op: fsnotify.Write},
+		{Name: "b", Op: fsnotify.Create},
+		{Name: "a", Op: fsnotify.Rename},
+		{Name: "b", Op: fsnotify.Create},
+		{Name: "a", Op: fsnotify.Write},
+		{Name: "a", Op: fsnotify.Remove},
+		{Name: "a", Op: fsnotify.Write},
+	}
+	got, received := q.take()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if len(received) != len(got) {
+		t.Fatalf("got %d receive times for %d events", len(received), len(got))
+	}
+	if got, _ := q.take(); got != nil {
+		t.Fatalf("queue not empty after take: %v", got)
+	}
+}
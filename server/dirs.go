@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fs-watcher-server/utils"
+	"io/fs"
+	"time"
+)
+
+// fsDirData is the stat of a directory, taken when a scan or an event saw it.
+type fsDirData struct {
+	Path    string
+	Rel     string
+	ModTime time.Time
+	Mode    fs.FileMode
+}
+
+func newDirData(name, rel string, info fs.FileInfo) fsDirData {
+	return fsDirData{Path: name, Rel: rel, ModTime: info.ModTime(), Mode: info.Mode()}
+}
+
+func (d fsDirData) same(o fsDirData) bool {
+	return d.Path == o.Path && d.Rel == o.Rel && d.ModTime.Equal(o.ModTime) && d.Mode == o.Mode
+}
+
+func (d fsDirData) stat() *fileStat {
+	return &fileStat{ModTime: d.ModTime, Mode: d.Mode.String()}
+}
+
+// loadDir stats the directory name, it returns false if it is not a directory
+// that is served.
+func (s *FsServer) loadDir(name string) (fsDirData, bool) {
+	stat, err := s.stat(name)
+	if err != nil || !stat.IsDir() || s.isIgnored(name, true) {
+		return fsDirData{}, false
+	}
+	return newDirData(name, s.relPath(name), stat), true
+}
+
+// storeDirs stores the stat of dirs in a single version of the store, if any
+// changed.
+func (s *FsServer) storeDirs(dirs []fsDirData) {
+	if len(dirs) == 0 {
+		return
+	}
+	s.loadedFiles.Commit(func(version uint64) bool {
+		changed := false
+		for _, d := range dirs {
+			if s.index.setDir(version, d) {
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// removeDir drops the directory at key and the directories under it, once
+// their files are removed.
+func (s *FsServer) removeDir(key string) {
+	s.loadedFiles.Commit(func(version uint64) bool {
+		return s.index.removeDir(version, key)
+	})
+}
+
+// storedDirs returns the stat of every directory of the latest version.
+func (s *FsServer) storedDirs() (res []fsDirData) {
+	s.loadedFiles.View(utils.Latest, func(utils.View[string, fsFileData]) {
+		res = s.index.dirs()
+	})
+	return res
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ChangeOp is the kind of a Change.
+type ChangeOp string
+
+const (
+	OpCreated ChangeOp = "created"
+	OpUpdated ChangeOp = "updated"
+	OpRemoved ChangeOp = "removed"
+	OpRenamed ChangeOp = "renamed"
+)
+
+const (
+	defaultJournalSize   = 4096
+	subscriberBufferSize = 64
+)
+
+// Change is an entry of the journal of the store mutations. Changes are
+// numbered from 1 by Seq, OldPath is only set when a file is renamed.
+type Change struct {
+	Seq     uint64    `json:"seq"`
+	Op      ChangeOp  `json:"op"`
+	Path    string    `json:"path"`
+	OldPath string    `json:"old_path,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	Time    time.Time `json:"time"`
+	Meta    any       `json:"meta,omitempty"`
+}
+
+// journaled tells whether storing entry over old is a change of the journal,
+// which only tells the path, hash and frontmatter of the files. A file
+// written with the same contents only changes its stat.
+func journaled(old, entry fsFileData) bool {
+	return old.Hash != entry.Hash || old.Path != entry.Path || !reflect.DeepEqual(old.Meta, entry.Meta)
+}
+
+// changeBroker is the journal of store mutations: it assigns sequence numbers,
+// keeps the most recent ones in a bounded backlog and fans them out to
+// subscribers.
+type changeBroker struct {
+	clock   Clock
+	lock    sync.Mutex
+	seq     uint64
+	backlog []Change
+	size    int
+	subs    map[chan Change]struct{}
+}
+
+func newChangeBroker(size int, clock Clock) *changeBroker {
+	return &changeBroker{
+		clock: clock,
+		size:  size,
+		subs:  map[chan Change]struct{}{},
+	}
+}
+
+func (b *changeBroker) publish(e Change) Change {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.seq++
+	e.Seq = b.seq
+	if e.Time.IsZero() {
+		e.Time = b.clock.Now()
+	}
+
+	b.backlog = append(b.backlog, e)
+	if len(b.backlog) > b.size {
+		b.backlog = append(b.backlog[:0:0], b.backlog[len(b.backlog)-b.size:]...)
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// the subscriber is too slow, drop it so it reconnects with Last-Event-ID
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return e
+}
+
+// subscribe registers a new subscriber. When resume is set, every backlog event
+// after lastSeq is returned as missed; ok is false if some of those events are
+// no longer in the backlog, in which case head is the sequence the subscriber
+// is starting from.
+func (b *changeBroker) subscribe(lastSeq uint64, resume bool) (ch chan Change, missed []Change, head uint64, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	head = b.seq
+	ok = true
+	if resume {
+		missed, ok = b.sinceLocked(lastSeq)
+	}
+
+	ch = make(chan Change, subscriberBufferSize)
+	b.subs[ch] = struct{}{}
+	return
+}
+
+func (b *changeBroker) unsubscribe(ch chan Change) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// since returns every change after seq. ok is false if the journal no longer
+// holds all of them and the caller has to resync from scratch.
+func (b *changeBroker) since(seq uint64) (changes []Change, head uint64, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	changes, ok = b.sinceLocked(seq)
+	return changes, b.seq, ok
+}
+
+func (b *changeBroker) sinceLocked(seq uint64) ([]Change, bool) {
+	if seq == b.seq {
+		return nil, true
+	}
+	if seq > b.seq || len(b.backlog) == 0 || b.backlog[0].Seq > seq+1 {
+		return nil, false
+	}
+
+	i := len(b.backlog) - int(b.seq-seq)
+	return append([]Change(nil), b.backlog[i:]...), true
+}
+
+// stats returns the sequence of the last change and the number of subscribers.
+func (b *changeBroker) stats() (head uint64, subscribers int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.seq, len(b.subs)
+}
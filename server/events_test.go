@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openEvents connects to /events, resuming after lastID unless it is empty.
+// Every event is sent on the returned channel as "<id> <event> <path>".
+func openEvents(t *testing.T, url, lastID string) <-chan string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	events := make(chan string, 16)
+	go func() {
+		defer resp.Body.Close()
+		sc := bufio.NewScanner(resp.Body)
+		var id, event string
+		var change Change
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				change = Change{}
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &change)
+			case line == "" && event != "":
+				events <- strings.TrimSpace(fmt.Sprintf("%s %s %s", id, event, change.Path))
+				id, event = "", ""
+			}
+		}
+	}()
+	return events
+}
+
+func expectEvents(t *testing.T, events <-chan string, want ...string) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for _, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Fatalf("got event %q, want %q", got, w)
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for event %q", w)
+		}
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"a.md": "a"}, func(opts *Options) {
+		opts.Config.JournalSize = 3
+	})
+	srv := httptest.NewServer(ts.handler)
+	t.Cleanup(srv.Close)
+
+	head, _ := ts.changes.stats()
+	for _, name := range []string{"b.md", "c.md", "d.md", "e.md"} {
+		ts.fs.WriteFile(name, name)
+		ts.expectChanges(t, "created "+name)
+	}
+	seq := func(n uint64) string { return fmt.Sprint(head + n) }
+
+	// the changes after Last-Event-ID are replayed, then the live ones follow
+	resumed := openEvents(t, srv.URL, seq(1))
+	expectEvents(t, resumed,
+		seq(2)+" created c.md",
+		seq(3)+" created d.md",
+		seq(4)+" created e.md",
+	)
+
+	// the backlog no longer holds the change after head+1, the client is
+	// told to refetch everything and continues from the latest change
+	reset := openEvents(t, srv.URL, seq(0))
+	expectEvents(t, reset, seq(4)+" reset")
+
+	// without Last-Event-ID only the live changes are sent
+	live := openEvents(t, srv.URL, "")
+
+	// up to date clients resume with nothing missed
+	current := openEvents(t, srv.URL, seq(4))
+
+	ts.fs.WriteFile("f.md", "f")
+	ts.expectChanges(t, "created f.md")
+	for _, events := range []<-chan string{resumed, reset, live, current} {
+		expectEvents(t, events, seq(5)+" created f.md")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "x")
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d for an invalid Last-Event-ID, want 400", rec.Code)
+	}
+}
+
+func TestJournalUnchanged(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"a.md": "---\ntitle: A\n---\na"})
+	head, _ := ts.changes.stats()
+	before := ts.loadedFiles.Get("a.md")
+
+	// the same contents written again only change the stat of the file, the
+	// write after it tells when it was applied
+	ts.clock.Advance(time.Minute)
+	ts.fs.WriteFile("a.md", "---\ntitle: A\n---\na")
+	ts.fs.WriteFile("b.md", "b")
+	ts.expectChanges(t, "created b.md")
+
+	var changes struct {
+		Changes []Change `json:"changes"`
+	}
+	ts.get(t, fmt.Sprintf("/changes?since=%d", head), &changes)
+	if len(changes.Changes) != 1 || changes.Changes[0].Path != "b.md" {
+		t.Fatalf("unexpected changes: %+v", changes.Changes)
+	}
+	if after := ts.loadedFiles.Get("a.md"); !after.ModTime.After(before.ModTime) {
+		t.Fatalf("stat not stored: %v, then %v", before.ModTime, after.ModTime)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const sseKeepAlive = 15 * time.Second
+
+func (s *FsServer) handleEvents(c echo.Context) (err error) {
+	var lastSeq uint64
+	resume := false
+	if id := c.Request().Header.Get("Last-Event-ID"); id != "" {
+		lastSeq, err = strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid Last-Event-ID")
+		}
+		resume = true
+	}
+
+	ch, missed, head, ok := s.changes.subscribe(lastSeq, resume)
+	defer s.changes.unsubscribe(ch)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if !ok {
+		// the client missed more than the backlog holds, it has to refetch everything
+		if _, err = fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", head); err != nil {
+			return nil
+		}
+	}
+	for _, e := range missed {
+		if err = writeSSE(w, e); err != nil {
+			return nil
+		}
+	}
+	w.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, open := <-ch:
+			if !open {
+				return nil
+			}
+			if err = writeSSE(w, e); err != nil {
+				return nil
+			}
+			w.Flush()
+
+		case <-keepAlive.C:
+			if _, err = fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+
+		case <-c.Request().Context().Done():
+			return nil
+
+		case <-s.closing:
+			// the client reconnects with Last-Event-ID to another instance,
+			// or to this one once restarted
+			return nil
+		}
+	}
+}
+
+func writeSSE(w *echo.Response, e Change) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Op, data)
+	return err
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = wsPongTimeout * 9 / 10
+)
+
+// wsRequest is a message sent by the client. Patterns use doublestar syntax
+// and are matched against the relative path of the changed file.
+type wsRequest struct {
+	Type     string   `json:"type"`
+	Patterns []string `json:"patterns"`
+}
+
+type wsResponse struct {
+	Type     string   `json:"type"`
+	Patterns []string `json:"patterns,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Change   *Change  `json:"change,omitempty"`
+}
+
+type wsFilter struct {
+	lock     sync.RWMutex
+	patterns map[string]struct{}
+}
+
+func (f *wsFilter) update(typ string, patterns []string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, p := range patterns {
+		if typ == "subscribe" {
+			f.patterns[p] = struct{}{}
+		} else {
+			delete(f.patterns, p)
+		}
+	}
+
+	res := make([]string, 0, len(f.patterns))
+	for p := range f.patterns {
+		res = append(res, p)
+	}
+	return res
+}
+
+func (f *wsFilter) match(e Change) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	for p := range f.patterns {
+		if ok, _ := doublestar.Match(p, e.Path); ok {
+			return true
+		}
+		if e.OldPath != "" {
+			if ok, _ := doublestar.Match(p, e.OldPath); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkWsOrigin accepts the origins allowed by CorsOrigins, or only the same
+// origin when none are configured. Clients that send no Origin are not
+// browsers and are accepted.
+func (s *FsServer) checkWsOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	s.configLock.RLock()
+	origins := s.CorsOrigins
+	s.configLock.RUnlock()
+
+	if len(origins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *FsServer) handleWs(c echo.Context) error {
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkWsOrigin}
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	ch, _, _, _ := s.changes.subscribe(0, false)
+	defer s.changes.unsubscribe(ch)
+
+	filter := &wsFilter{patterns: map[string]struct{}{}}
+	replies := make(chan wsResponse, 1)
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+
+		_ = conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		})
+
+		for {
+			var req wsRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+
+			var resp wsResponse
+			switch req.Type {
+			case "subscribe", "unsubscribe":
+				resp.Type = req.Type + "d"
+				for _, p := range req.Patterns {
+					if !doublestar.ValidatePattern(p) {
+						resp = wsResponse{Type: "error", Error: "invalid pattern: " + p}
+						break
+					}
+				}
+				if resp.Type != "error" {
+					resp.Patterns = filter.update(req.Type, req.Patterns)
+				}
+			default:
+				resp = wsResponse{Type: "error", Error: "unknown message type: " + req.Type}
+			}
+
+			select {
+			case replies <- resp:
+			case <-c.Request().Context().Done():
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	write := func(resp wsResponse) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(resp)
+	}
+
+	for {
+		select {
+		case e, open := <-ch:
+			if !open {
+				// the client fell behind, it needs to reconnect and refetch
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"),
+					time.Now().Add(wsWriteTimeout))
+				return nil
+			}
+			if !filter.match(e) {
+				continue
+			}
+			if err := write(wsResponse{Type: "change", Change: &e}); err != nil {
+				log.Warnf("ws: %v", err)
+				return nil
+			}
+
+		case resp := <-replies:
+			if err := write(resp); err != nil {
+				log.Warnf("ws: %v", err)
+				return nil
+			}
+
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return nil
+			}
+
+		case <-closed:
+			return nil
+
+		case <-s.closing:
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+				time.Now().Add(wsWriteTimeout))
+			return nil
+		}
+	}
+}
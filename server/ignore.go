@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/bmatcuk/doublestar/v4"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+var defaultExclude = []string{"**/.*", "**/*~"}
+
+var ignoreFileNames = []string{".gitignore", ".ignore"}
+
+// ignoreRules decides which paths of a root are loaded. Paths are
+// slash-separated and relative to the root. Exclude patterns apply to files
+// and directories, include patterns only to files.
+type ignoreRules struct {
+	fsys      fs.FS
+	include   []string
+	exclude   []string
+	gitIgnore bool
+
+	lock       sync.Mutex
+	gitIgnores map[string][]ignorePattern
+}
+
+// ignorePattern is a single line of a .gitignore file, translated to a
+// doublestar pattern relative to the directory containing the file.
+type ignorePattern struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+func newIgnoreRules(fsys fs.FS, include, exclude []string, gitIgnore bool) (*ignoreRules, error) {
+	for _, p := range append(append([]string{}, include...), exclude...) {
+		if !doublestar.ValidatePattern(p) {
+			return nil, fmt.Errorf("invalid pattern: %q", p)
+		}
+	}
+	return &ignoreRules{
+		fsys:       fsys,
+		include:    include,
+		exclude:    exclude,
+		gitIgnore:  gitIgnore,
+		gitIgnores: map[string][]ignorePattern{},
+	}, nil
+}
+
+func (r *ignoreRules) ignored(rel string, isDir bool) bool {
+	if rel == "." || rel == "" {
+		return false
+	}
+
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if r.excluded(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	if r.excluded(rel, isDir) {
+		return true
+	}
+	if !isDir && len(r.include) > 0 && !matchAny(r.include, rel) {
+		return true
+	}
+	return false
+}
+
+func (r *ignoreRules) excluded(rel string, isDir bool) bool {
+	if matchAny(r.exclude, rel) {
+		return true
+	}
+	if !r.gitIgnore {
+		return false
+	}
+
+	// the deepest matching pattern wins, like git does
+	ignored := false
+	dir := ""
+	rest := rel
+	for {
+		for _, p := range r.loadGitIgnore(dir) {
+			if p.dirOnly && !isDir {
+				continue
+			}
+			if ok, _ := doublestar.Match(p.pattern, rest); ok {
+				ignored = !p.negate
+			}
+		}
+
+		i := strings.IndexByte(rest, '/')
+		if i < 0 {
+			return ignored
+		}
+		dir = path.Join(dir, rest[:i])
+		rest = rest[i+1:]
+	}
+}
+
+// invalidate drops the cached ignore files of a directory, it returns true if
+// the name is an ignore file and the rules may have changed.
+func (r *ignoreRules) invalidate(rel string) bool {
+	if !r.gitIgnore || !isIgnoreFile(rel) {
+		return false
+	}
+
+	dir := path.Dir(rel)
+	if dir == "." {
+		dir = ""
+	}
+	r.lock.Lock()
+	delete(r.gitIgnores, dir)
+	r.lock.Unlock()
+	return true
+}
+
+func (r *ignoreRules) loadGitIgnore(dir string) []ignorePattern {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if patterns, ok := r.gitIgnores[dir]; ok {
+		return patterns
+	}
+
+	var patterns []ignorePattern
+	for _, n := range ignoreFileNames {
+		f, err := r.fsys.Open(path.Join(dir, n))
+		if err != nil {
+			continue
+		}
+		patterns = append(patterns, parseGitIgnore(f)...)
+		_ = f.Close()
+	}
+	r.gitIgnores[dir] = patterns
+	return patterns
+}
+
+func parseGitIgnore(f io.Reader) []ignorePattern {
+	var patterns []ignorePattern
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\")
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// a pattern with a slash is relative to the .gitignore, otherwise it
+		// matches at any depth
+		if strings.Contains(line, "/") {
+			p.pattern = strings.TrimPrefix(line, "/")
+		} else {
+			p.pattern = "**/" + line
+		}
+		if !doublestar.ValidatePattern(p.pattern) {
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+func isIgnoreFile(rel string) bool {
+	n := path.Base(rel)
+	for _, f := range ignoreFileNames {
+		if n == f {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if ok, _ := doublestar.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"fs-watcher-server/utils"
+	"sort"
+	"strings"
+)
+
+// dirNode is a directory of the served namespace. A directory exists at a
+// version of the store once a scan or an event saw it, with its own stat, or
+// while it holds a file at that version at any depth, the watcher may report
+// a file before its directory. The nodes of the directories removed since a
+// retained version are kept.
+type dirNode struct {
+	name   string
+	parent *dirNode
+	dirs   map[string]*dirNode
+	// files maps the names of the files to the version they were removed at,
+	// 0 while they exist
+	files map[string]uint64
+
+	// revs holds the stat of the directory, the number of files under it and
+	// their total size, from each version they changed at. The last one is
+	// the latest.
+	revs []dirRev
+}
+
+type dirRev struct {
+	version   uint64
+	fileCount int
+	size      int64
+	// dir is the stat of the directory itself, nil before it was seen and
+	// once it was removed
+	dir *fsDirData
+}
+
+func (r dirRev) exists() bool {
+	return r.dir != nil || r.fileCount > 0
+}
+
+func newDirNode(name string, parent *dirNode) *dirNode {
+	return &dirNode{
+		name:   name,
+		parent: parent,
+		dirs:   map[string]*dirNode{},
+		files:  map[string]uint64{},
+	}
+}
+
+// at returns the files under the directory at version, none if it did not
+// exist.
+func (d *dirNode) at(version uint64) dirRev {
+	i := sort.Search(len(d.revs), func(i int) bool { return d.revs[i].version > version })
+	if i == 0 {
+		return dirRev{}
+	}
+	return d.revs[i-1]
+}
+
+func (d *dirNode) latest() dirRev {
+	if len(d.revs) == 0 {
+		return dirRev{}
+	}
+	return d.revs[len(d.revs)-1]
+}
+
+// change adds files and size to the directory at version, which is the
+// latest.
+func (d *dirNode) change(version uint64, files int, size int64) {
+	r := d.latest()
+	r.fileCount += files
+	r.size += size
+	d.set(version, r)
+}
+
+// setDir sets the stat of the directory at version, which is the latest.
+func (d *dirNode) setDir(version uint64, dir *fsDirData) {
+	r := d.latest()
+	r.dir = dir
+	d.set(version, r)
+}
+
+func (d *dirNode) set(version uint64, r dirRev) {
+	r.version = version
+	if n := len(d.revs); n > 0 && d.revs[n-1].version == version {
+		d.revs[n-1] = r
+		return
+	}
+	d.revs = append(d.revs, r)
+}
+
+// trim drops the revisions that no version from floor on can see.
+func (d *dirNode) trim(floor uint64) {
+	i := sort.Search(len(d.revs), func(i int) bool { return d.revs[i].version > floor })
+	if i > 1 {
+		d.revs = append(d.revs[:0:0], d.revs[i-1:]...)
+	}
+}
+
+// dirIndex lists the files and directories of every directory of the store,
+// at every retained version. It is updated by loadedFiles with the map
+// locked, for the directories through loadedFiles.Commit, so reading it from a
+// view is consistent with the files of the view.
+type dirIndex struct {
+	root *dirNode
+	// changed lists the keys changed at each version, in version order, their
+	// revisions are trimmed once the versions before are no longer retained
+	changed []indexChange
+}
+
+type indexChange struct {
+	version uint64
+	key     string
+	dir     bool
+}
+
+func newDirIndex() *dirIndex {
+	return &dirIndex{root: newDirNode("", nil)}
+}
+
+// update is called by loadedFiles when the file at key is stored or removed
+// at version.
+func (x *dirIndex) update(version uint64, key string, old, entry *fsFileData) {
+	if old != nil {
+		x.remove(version, key, old.Size)
+	}
+	if entry != nil {
+		x.add(version, key, entry.Size)
+	}
+	x.changed = append(x.changed, indexChange{version: version, key: key})
+}
+
+func (x *dirIndex) add(version uint64, key string, size int64) {
+	parts := strings.Split(key, "/")
+	d := x.root
+	for _, name := range parts[:len(parts)-1] {
+		d.change(version, 1, size)
+		child, ok := d.dirs[name]
+		if !ok {
+			child = newDirNode(name, d)
+			d.dirs[name] = child
+		}
+		d = child
+	}
+	d.change(version, 1, size)
+	d.files[parts[len(parts)-1]] = 0
+}
+
+func (x *dirIndex) remove(version uint64, key string, size int64) {
+	dir, name := splitKey(key)
+	path := x.path(dir)
+	if len(path) != strings.Count(key, "/")+1 {
+		return
+	}
+	d := path[len(path)-1]
+	if removed, ok := d.files[name]; !ok || removed != 0 {
+		return
+	}
+	d.files[name] = version
+	for ; d != nil; d = d.parent {
+		d.change(version, -1, -size)
+	}
+}
+
+// setDir stores the stat of the directory at dir.Rel at version, creating it
+// and its parents. It returns false if the stat did not change.
+func (x *dirIndex) setDir(version uint64, dir fsDirData) bool {
+	d := x.root
+	if dir.Rel != "" {
+		for _, name := range strings.Split(dir.Rel, "/") {
+			child, ok := d.dirs[name]
+			if !ok {
+				child = newDirNode(name, d)
+				d.dirs[name] = child
+			}
+			d = child
+		}
+	}
+	if old := d.latest().dir; old != nil && old.same(dir) {
+		return false
+	}
+	d.setDir(version, &dir)
+	x.changed = append(x.changed, indexChange{version: version, key: dir.Rel, dir: true})
+	return true
+}
+
+// removeDir removes the directory at key and every directory under it at
+// version, their files are removed by loadedFiles. It returns false if none
+// was seen.
+func (x *dirIndex) removeDir(version uint64, key string) bool {
+	path := x.path(key)
+	if key != "" && len(path) != strings.Count(key, "/")+2 {
+		return false
+	}
+	removed := false
+	var walk func(d *dirNode, key string)
+	walk = func(d *dirNode, key string) {
+		if d.latest().dir != nil {
+			d.setDir(version, nil)
+			x.changed = append(x.changed, indexChange{version: version, key: key, dir: true})
+			removed = true
+		}
+		for name, child := range d.dirs {
+			walk(child, joinKey(key, name))
+		}
+	}
+	walk(path[len(path)-1], key)
+	return removed
+}
+
+// dirs returns the stat of every directory seen in the latest version.
+func (x *dirIndex) dirs() []fsDirData {
+	var res []fsDirData
+	var walk func(d *dirNode)
+	walk = func(d *dirNode) {
+		if dir := d.latest().dir; dir != nil {
+			res = append(res, *dir)
+		}
+		for _, child := range d.dirs {
+			walk(child)
+		}
+	}
+	walk(x.root)
+	return res
+}
+
+// prune is called by loadedFiles when the versions before floor are no
+// longer retained, it drops what only they could see.
+func (x *dirIndex) prune(floor uint64) {
+	n := sort.Search(len(x.changed), func(i int) bool { return x.changed[i].version > floor })
+	for _, c := range x.changed[:n] {
+		x.trim(c, floor)
+	}
+	if n > 0 {
+		x.changed = append(x.changed[:0:0], x.changed[n:]...)
+	}
+}
+
+// trim drops the revisions of the directories of a change that no version
+// from floor on can see, and the file and directories removed before it.
+func (x *dirIndex) trim(c indexChange, floor uint64) {
+	var path []*dirNode
+	if c.dir {
+		if path = x.path(c.key); c.key != "" && len(path) != strings.Count(c.key, "/")+2 {
+			path = path[:0]
+		}
+	} else {
+		dir, name := splitKey(c.key)
+		path = x.path(dir)
+		if len(path) == strings.Count(c.key, "/")+1 {
+			d := path[len(path)-1]
+			if removed := d.files[name]; removed != 0 && removed <= floor {
+				delete(d.files, name)
+			}
+		}
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		d := path[i]
+		d.trim(floor)
+		if i > 0 && len(d.revs) <= 1 && !d.latest().exists() && len(d.dirs) == 0 && len(d.files) == 0 {
+			delete(path[i-1].dirs, d.name)
+		}
+	}
+}
+
+// path returns the nodes from the root to the directory at key, whether it
+// exists at a retained version or not. It stops at the first one missing.
+func (x *dirIndex) path(key string) []*dirNode {
+	res := []*dirNode{x.root}
+	if key == "" {
+		return res
+	}
+	d := x.root
+	for _, name := range strings.Split(key, "/") {
+		if d = d.dirs[name]; d == nil {
+			break
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+// lookup returns the directory at key if it exists at version, or nil. The
+// root is at "" and always exists.
+func (x *dirIndex) lookup(key string, version uint64) *dirNode {
+	d := x.root
+	if key == "" {
+		return d
+	}
+	for _, name := range strings.Split(key, "/") {
+		d = d.dirs[name]
+		if d == nil {
+			return nil
+		}
+	}
+	if !d.at(version).exists() {
+		return nil
+	}
+	return d
+}
+
+// list calls dir for the directories and file for the files directly under
+// the directory d at key, at the version of view.
+func (x *dirIndex) list(view utils.View[string, fsFileData], d *dirNode, key string, dir func(name string, child *dirNode, stat dirRev), file func(name string, entry fsFileData)) {
+	version, latest := view.Version(), view.IsLatest()
+	for name, child := range d.dirs {
+		if stat := child.at(version); stat.exists() {
+			dir(name, child, stat)
+		}
+	}
+	for name, removed := range d.files {
+		if latest && removed != 0 {
+			continue
+		}
+		if entry, ok := view.TryGet(joinKey(key, name)); ok {
+			file(name, entry)
+		}
+	}
+}
+
+// under returns the keys of the file at key, or of every file under the
+// directory at key, in the latest version.
+func (x *dirIndex) under(key string) []string {
+	dir, name := splitKey(key)
+	if d := x.lookup(dir, utils.Latest); d != nil {
+		if removed, ok := d.files[name]; ok && removed == 0 {
+			return []string{key}
+		}
+	}
+
+	d := x.lookup(key, utils.Latest)
+	if d == nil {
+		return nil
+	}
+	res := make([]string, 0, d.latest().fileCount)
+	var walk func(d *dirNode, prefix string)
+	walk = func(d *dirNode, prefix string) {
+		for name, removed := range d.files {
+			if removed == 0 {
+				res = append(res, prefix+name)
+			}
+		}
+		for name, child := range d.dirs {
+			if child.latest().fileCount > 0 {
+				walk(child, prefix+name+"/")
+			}
+		}
+	}
+	prefix := ""
+	if key != "" {
+		prefix = key + "/"
+	}
+	walk(d, prefix)
+	return res
+}
+
+// splitKey splits a key into its directory and its name.
+func splitKey(key string) (dir, name string) {
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// joinKey returns the key of name in the directory at dir.
+func joinKey(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fs-watcher-server/fake"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirIndex(t *testing.T) {
+	x := newDirIndex()
+	x.update(1, "a.md", nil, &fsFileData{Size: 1})
+	x.update(2, "docs/b.md", nil, &fsFileData{Size: 2})
+	x.update(3, "docs/sub/c.md", nil, &fsFileData{Size: 3})
+	x.update(4, "docs/sub/deep/d.md", nil, &fsFileData{Size: 4})
+
+	if root := x.root.latest(); root.fileCount != 4 || root.size != 10 {
+		t.Fatalf("root has %d files and %d bytes, want 4 and 10", root.fileCount, root.size)
+	}
+	docs := x.lookup("docs", 4)
+	if docs == nil || len(docs.files) != 1 || len(docs.dirs) != 1 || docs.latest() != (dirRev{version: 4, fileCount: 3, size: 9}) {
+		t.Fatalf("unexpected docs: %+v", docs)
+	}
+	if x.lookup("docs/sub", 2) != nil || x.lookup("docs", 1) != nil {
+		t.Fatal("directories found before their first file")
+	}
+
+	under := func(key string) string {
+		keys := x.under(key)
+		sort.Strings(keys)
+		return strings.Join(keys, " ")
+	}
+	if got := under("docs/sub"); got != "docs/sub/c.md docs/sub/deep/d.md" {
+		t.Fatalf("got %q under docs/sub", got)
+	}
+	if got := under("a.md"); got != "a.md" {
+		t.Fatalf("got %q under a.md", got)
+	}
+	if got := under("doc"); got != "" {
+		t.Fatalf("got %q under a prefix that is not a directory", got)
+	}
+
+	// the directories left empty are gone from the next versions only
+	x.update(5, "docs/sub/deep/d.md", &fsFileData{Size: 4}, nil)
+	x.update(6, "docs/sub/c.md", &fsFileData{Size: 3}, &fsFileData{Size: 3})
+	x.update(7, "docs/sub/c.md", &fsFileData{Size: 3}, nil)
+	if x.lookup("docs/sub", 7) != nil || x.lookup("docs/sub/deep", 5) != nil {
+		t.Fatal("empty directories still in the index")
+	}
+	if sub := x.lookup("docs/sub", 6); sub == nil || sub.at(6) != (dirRev{version: 6, fileCount: 1, size: 3}) {
+		t.Fatalf("unexpected docs/sub at version 6: %+v", sub)
+	}
+	if got := under("docs"); got != "docs/b.md" {
+		t.Fatalf("got %q under docs", got)
+	}
+	if docs.latest().fileCount != 1 || docs.latest().size != 2 || x.root.latest().fileCount != 2 || x.root.latest().size != 3 {
+		t.Fatalf("unexpected sizes after the removal: %+v", docs)
+	}
+
+	// once the versions before 7 are no longer retained, what only they
+	// could see is dropped
+	x.prune(7)
+	if len(docs.dirs) != 0 || len(docs.revs) != 1 || len(x.root.revs) != 1 || len(x.changed) != 0 {
+		t.Fatalf("index not trimmed: %+v", docs)
+	}
+	if x.lookup("docs", 7) != docs || x.root.latest() != (dirRev{version: 7, fileCount: 2, size: 3}) {
+		t.Fatalf("unexpected index after the trim: %+v", x.root)
+	}
+
+	// a directory seen without files exists with its own stat
+	empty := fsDirData{Rel: "empty", Mode: fs.ModeDir | 0o755}
+	if !x.setDir(8, empty) || x.setDir(9, empty) {
+		t.Fatal("unchanged stat stored again")
+	}
+	x.setDir(8, fsDirData{Rel: "empty/sub", Mode: fs.ModeDir | 0o755})
+	if d := x.lookup("empty", 8); d == nil || d.latest().dir == nil || d.latest().fileCount != 0 || x.lookup("empty", 7) != nil {
+		t.Fatalf("unexpected empty directory: %+v", d)
+	}
+	if !x.removeDir(9, "empty") || x.removeDir(9, "missing") {
+		t.Fatal("unexpected removals")
+	}
+	if x.lookup("empty/sub", 9) != nil || x.lookup("empty/sub", 8) == nil {
+		t.Fatal("directory under a removed one not removed from its version only")
+	}
+	x.prune(9)
+	if _, ok := x.root.dirs["empty"]; ok {
+		t.Fatal("removed directory not trimmed")
+	}
+}
+
+func TestReadDirIndex(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"a.md":          "a",
+		"docs/b.md":     "bb",
+		"docs/sub/c.md": "ccc",
+	})
+
+	type entry struct {
+		Dir   bool   `json:"dir"`
+		Path  string `json:"path"`
+		Files int    `json:"files"`
+		Size  int64  `json:"size"`
+	}
+	var entries []entry
+	ts.get(t, "/readdir?d=/&m=1", &entries)
+	if len(entries) != 2 || entries[0].Dir || entries[1] != (entry{Dir: true, Path: "docs", Files: 2, Size: 5}) {
+		t.Fatalf("unexpected root entries: %+v", entries)
+	}
+
+	rec := ts.request(t, http.MethodGet, "/readdir?d=docs&m=1", "")
+	version := rec.Header().Get(headerSnapshotVersion)
+
+	// an older version is listed from the files it had
+	ts.fs.Remove("docs/sub")
+	ts.expectChanges(t, "removed docs/sub/c.md")
+	entries = nil
+	ts.get(t, "/readdir?d=docs&m=1", &entries)
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	entries = nil
+	ts.get(t, "/readdir?d=docs&m=1&at="+version, &entries)
+	if len(entries) != 2 || entries[1] != (entry{Dir: true, Path: "sub", Files: 1, Size: 3}) {
+		t.Fatalf("unexpected entries at %s: %+v", version, entries)
+	}
+}
+
+func TestEmptyDirectory(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"docs/a.md": "a"}, func(opts *Options) {
+		opts.FS.(*fake.FS).Mkdir("scanned")
+	})
+
+	type entry struct {
+		Dir     bool       `json:"dir"`
+		Path    string     `json:"path"`
+		Files   int        `json:"files"`
+		ModTime *time.Time `json:"mod_time"`
+		Mode    string     `json:"mode"`
+	}
+	var entries []entry
+	ts.get(t, "/readdir?d=/&s=1", &entries)
+	if len(entries) != 2 || entries[1].Path != "scanned" || !entries[1].Dir || entries[1].ModTime == nil {
+		t.Fatalf("unexpected root entries: %+v", entries)
+	}
+
+	// a directory created without files is listed with its own stat, the
+	// write after it tells when it was applied
+	ts.clock.Advance(time.Minute)
+	created := ts.clock.Now()
+	ts.fs.Mkdir("docs/empty")
+	ts.fs.WriteFile("docs/b.md", "b")
+	ts.expectChanges(t, "created docs/b.md")
+	rec := ts.request(t, http.MethodGet, "/readdir?d=docs", "")
+	version := rec.Header().Get(headerSnapshotVersion)
+	entries = nil
+	ts.get(t, "/readdir?d=docs", &entries)
+	if len(entries) != 3 || entries[2] != (entry{Dir: true, Path: "empty"}) {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	var stats []entry
+	ts.get(t, "/stat?f=docs/empty", &stats)
+	if len(stats) != 1 || !stats[0].Dir || stats[0].ModTime == nil || !stats[0].ModTime.Equal(created) || stats[0].Mode != "drwxr-xr-x" {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// once removed, it is only listed at the versions that saw it
+	ts.fs.Remove("docs/empty")
+	ts.fs.Remove("docs/b.md")
+	ts.expectChanges(t, "removed docs/b.md")
+	entries = nil
+	ts.get(t, "/readdir?d=docs", &entries)
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries after the removal: %+v", entries)
+	}
+	entries = nil
+	ts.get(t, "/readdir?d=docs&at="+version, &entries)
+	if len(entries) != 3 {
+		t.Fatalf("unexpected entries at %s: %+v", version, entries)
+	}
+}
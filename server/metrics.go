@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"fs-watcher-server/utils"
+	"github.com/fsnotify/fsnotify"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "fs_watcher"
+
+var eventOps = []struct {
+	op   fsnotify.Op
+	name string
+}{
+	{fsnotify.Create, "create"},
+	{fsnotify.Write, "write"},
+	{fsnotify.Remove, "remove"},
+	{fsnotify.Rename, "rename"},
+	{fsnotify.Chmod, "chmod"},
+}
+
+// serverMetrics are the Prometheus metrics of a FsServer, served on /metrics.
+type serverMetrics struct {
+	registry *prometheus.Registry
+
+	events        *prometheus.CounterVec
+	filesLoaded   prometheus.Counter
+	filesRemoved  prometheus.Counter
+	parseErrors   *prometheus.CounterVec
+	updateLatency prometheus.Histogram
+	httpRequests  *prometheus.CounterVec
+	reprBuild     prometheus.Histogram
+}
+
+func newServerMetrics(s *FsServer) *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "watcher_events_total",
+			Help:      "Events received from the watcher, by operation.",
+		}, []string{"op"}),
+		filesLoaded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "files_loaded_total",
+			Help:      "Files read from disk.",
+		}),
+		filesRemoved: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "files_removed_total",
+			Help:      "Files dropped from the store.",
+		}),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "parse_errors_total",
+			Help:      "Files whose metadata could not be parsed, by parser.",
+		}, []string{"parser"}),
+		updateLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "update_latency_seconds",
+			Help:      "Time from a watcher event to the store being updated, debounce included.",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests, by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		reprBuild: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "repr_build_seconds",
+			Help:      "Time taken to rebuild the JSON representation served by /all.",
+			Buckets:   prometheus.ExponentialBuckets(.0001, 4, 10),
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.events, m.filesLoaded, m.filesRemoved, m.parseErrors,
+		m.updateLatency, m.httpRequests, m.reprBuild,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "store_files",
+			Help:      "Files held in the store.",
+		}, func() float64 {
+			files, _ := s.storeSize()
+			return float64(files)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "store_bytes",
+			Help:      "Bytes of file contents held in the store.",
+		}, func() float64 {
+			_, bytes := s.storeSize()
+			return float64(bytes)
+		}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func (m *serverMetrics) eventReceived(e fsnotify.Event) {
+	for _, o := range eventOps {
+		if e.Op&o.op != 0 {
+			m.events.WithLabelValues(o.name).Inc()
+		}
+	}
+}
+
+// storeSize returns the number of files in the store and their total size.
+func (s *FsServer) storeSize() (files int, bytes int64) {
+	s.loadedFiles.View(utils.Latest, func(utils.View[string, fsFileData]) {
+		stat := s.index.root.latest()
+		files, bytes = stat.fileCount, stat.size
+	})
+	return files, bytes
+}
+
+// metricsMiddleware counts the requests by route, the route is the pattern
+// the request matched so that the paths do not explode the label values.
+func (s *FsServer) metricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+
+		code := c.Response().Status
+		if err != nil {
+			code = http.StatusInternalServerError
+			var he *echo.HTTPError
+			if errors.As(err, &he) {
+				code = he.Code
+			}
+		}
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		s.metrics.httpRequests.WithLabelValues(route, c.Request().Method, strconv.Itoa(code)).Inc()
+		return err
+	}
+}
+
+func (s *FsServer) handleMetrics(c echo.Context) error {
+	promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+func (s *FsServer) observeLatency(received []time.Time) {
+	now := s.clock.Now()
+	for _, t := range received {
+		s.metrics.updateLatency.Observe(now.Sub(t).Seconds())
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"a.md":   "---\ntitle: A\n---\n",
+		"bad.md": "---\ntitle: [\n---\n",
+	})
+
+	ts.fs.WriteFile("c.txt", "ccc")
+	ts.expectChanges(t, "created c.txt")
+	ts.fs.Remove("a.md")
+	ts.expectChanges(t, "removed a.md")
+	ts.request(t, http.MethodGet, "/readFile?f=c.txt", "")
+	ts.request(t, http.MethodGet, "/readdir", "")
+
+	rec := ts.request(t, http.MethodGet, "/metrics", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`fs_watcher_watcher_events_total{op="create"} 1`,
+		`fs_watcher_watcher_events_total{op="remove"} 1`,
+		`fs_watcher_files_loaded_total 3`,
+		`fs_watcher_files_removed_total 1`,
+		`fs_watcher_parse_errors_total{parser="frontmatter"} 1`,
+		`fs_watcher_update_latency_seconds_count 2`,
+		`fs_watcher_http_requests_total{code="200",method="GET",route="/readFile"} 1`,
+		`fs_watcher_http_requests_total{code="400",method="GET",route="/readdir"} 1`,
+		`fs_watcher_store_files 2`,
+		`fs_watcher_store_bytes 20`,
+		`fs_watcher_repr_build_seconds_count`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package server
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"fs-watcher-server/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// headerNextCursor carries the cursor of the next page of a listing, it is
+// missing on the last page.
+const headerNextCursor = "X-Next-Cursor"
+
+// readDirEntry is an entry of a listing. Path is relative to the listed
+// directory. Contents and Meta are only set for files, when asked for with
+// m=1; Files and Size are always set for directories, and Size with the other
+// stat fields for files when asked for with s=1, like the stat of directories
+// that were seen.
+type readDirEntry struct {
+	Dir      bool   `json:"dir"`
+	Path     string `json:"path"`
+	Contents string `json:"contents,omitempty"`
+	Meta     any    `json:"meta,omitempty"`
+	Files    int    `json:"files,omitempty"`
+	Size     *int64 `json:"size,omitempty"`
+	*fileStat
+}
+
+// listedEntry is an entry found in the index, copied out of the locked view.
+// The size of a directory is kept in file.Size, with its mtime and mode when
+// it was seen and dirStat is set. key is the value it is sorted by unless it
+// is sorted by name, hasKey is false when it has no such value.
+type listedEntry struct {
+	path    string
+	dir     bool
+	files   int
+	file    fsFileData
+	dirStat *fileStat
+	key     any
+	hasKey  bool
+}
+
+// readDirCursor is where the next page of a listing starts: after the entry
+// at Path, sorted by Key. It pins the version of the first page, so that
+// every page is read from the same files for as long as the version is
+// retained, and the parameters of the listing, which the next pages must
+// repeat.
+type readDirCursor struct {
+	Version uint64     `json:"v"`
+	Dir     string     `json:"d"`
+	Depth   int        `json:"depth"`
+	Sort    string     `json:"sort"`
+	Order   string     `json:"order"`
+	Path    string     `json:"p"`
+	Key     *cursorKey `json:"k,omitempty"`
+}
+
+// cursorKey is the sort key of the last entry of a page. Rank is the one of
+// compareValues, so that the value can be decoded to the type it was
+// compared as.
+type cursorKey struct {
+	Rank  int             `json:"r"`
+	Value json.RawMessage `json:"v"`
+}
+
+func (c readDirCursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseReadDirCursor(s string) (readDirCursor, error) {
+	var c readDirCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.Path == "" || c.Version == utils.Latest {
+		return readDirCursor{}, echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+	}
+	return c, nil
+}
+
+// entry returns the last entry of the page before the cursor, as far as the
+// order of the entries is concerned.
+func (c readDirCursor) entry() (listedEntry, error) {
+	e := listedEntry{path: c.Path}
+	if c.Key == nil {
+		return e, nil
+	}
+
+	var err error
+	switch c.Key.Rank {
+	case 0:
+		var f float64
+		err = json.Unmarshal(c.Key.Value, &f)
+		e.key = f
+	case 1:
+		var t time.Time
+		err = json.Unmarshal(c.Key.Value, &t)
+		e.key = t
+	case 2:
+		var s string
+		err = json.Unmarshal(c.Key.Value, &s)
+		e.key = s
+	case 3:
+		var b bool
+		err = json.Unmarshal(c.Key.Value, &b)
+		e.key = b
+	default:
+		// compared by its JSON encoding, which is kept as is
+		e.key = c.Key.Value
+	}
+	if err != nil {
+		return listedEntry{}, echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+	}
+	e.hasKey = true
+	return e, nil
+}
+
+// after returns the cursor of the page following e.
+func (c readDirCursor) after(e listedEntry) readDirCursor {
+	c.Path, c.Key = e.path, nil
+	if e.hasKey {
+		value, err := json.Marshal(e.key)
+		if err == nil {
+			c.Key = &cursorKey{Rank: valueRank(e.key), Value: value}
+		}
+	}
+	return c
+}
+
+// handleReadDir lists a directory down to depth, -1 for no limit. The entries
+// are sorted by path, or by sort: mtime, size or meta.<field> for a
+// frontmatter field, the entries without the field coming last. A page of
+// limit entries comes with the cursor of the next one, which must be passed
+// with the same parameters.
+func (s *FsServer) handleReadDir(c echo.Context) (err error) {
+	data := struct {
+		Dir         string `query:"d" json:"dir"`
+		IncludeMeta bool   `query:"m" json:"include_meta"`
+		IncludeStat bool   `query:"s" json:"include_stat"`
+		Depth       int    `query:"depth" json:"depth"`
+		Sort        string `query:"sort" json:"sort"`
+		Order       string `query:"order" json:"order"`
+		Limit       int    `query:"limit" json:"limit"`
+		Cursor      string `query:"cursor" json:"cursor"`
+	}{Depth: 1}
+
+	err = c.Bind(&data)
+	if err != nil {
+		return
+	}
+
+	if data.Dir == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing dir")
+	}
+	if data.Depth == 0 || data.Depth < -1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "depth: must be positive, or -1 for no limit")
+	}
+	if data.Limit < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit: must not be negative")
+	}
+	order, err := readDirOrder(data.Sort, data.Order)
+	if err != nil {
+		return err
+	}
+
+	key := strings.Trim(data.Dir, "/")
+	cursor := readDirCursor{Dir: key, Depth: data.Depth, Sort: order.by, Order: "asc"}
+	if order.desc {
+		cursor.Order = "desc"
+	}
+
+	// a cursor reads the version of the first page, and only the entries
+	// after its own
+	at, err := snapshotAt(c)
+	if err != nil {
+		return err
+	}
+	var last *listedEntry
+	if data.Cursor != "" {
+		prev, err := parseReadDirCursor(data.Cursor)
+		if err != nil {
+			return err
+		}
+		if prev.Dir != cursor.Dir || prev.Depth != cursor.Depth || prev.Sort != cursor.Sort || prev.Order != cursor.Order {
+			return echo.NewHTTPError(http.StatusBadRequest, "cursor: d, depth, sort and order must be the ones of the first page")
+		}
+		e, err := prev.entry()
+		if err != nil {
+			return err
+		}
+		at, last = prev.Version, &e
+	}
+
+	page := newReadDirPage(order, last, data.Limit)
+	err = s.viewIndex(c, at, func(view utils.View[string, fsFileData], index *dirIndex) {
+		cursor.Version = view.Version()
+		dir := index.lookup(key, view.Version())
+		if dir == nil {
+			return
+		}
+
+		// dirKey is the key of d, prefix its path relative to the listed
+		// directory
+		var walk func(d *dirNode, dirKey, prefix string, depth int)
+		walk = func(d *dirNode, dirKey, prefix string, depth int) {
+			index.list(view, d, dirKey, func(name string, child *dirNode, stat dirRev) {
+				e := listedEntry{
+					path:  prefix + name,
+					dir:   true,
+					files: stat.fileCount,
+					file:  fsFileData{Size: stat.size},
+				}
+				if stat.dir != nil {
+					e.file.ModTime, e.file.Mode = stat.dir.ModTime, stat.dir.Mode
+					e.dirStat = stat.dir.stat()
+				}
+				page.add(e)
+				if (data.Depth < 0 || depth < data.Depth) && !page.skips(prefix+name+"/") {
+					walk(child, joinKey(dirKey, name), prefix+name+"/", depth+1)
+				}
+			}, func(name string, file fsFileData) {
+				page.add(listedEntry{path: prefix + name, file: file})
+			})
+		}
+		walk(dir, key, "", 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	entries, more := page.entries()
+	if more {
+		c.Response().Header().Set(headerNextCursor, cursor.after(entries[len(entries)-1]).String())
+	}
+
+	resp := make([]readDirEntry, 0, len(entries))
+	for _, e := range entries {
+		entry := readDirEntry{Dir: e.dir, Path: e.path}
+		size := e.file.Size
+		if e.dir {
+			entry.Files, entry.Size = e.files, &size
+			if data.IncludeStat {
+				entry.fileStat = e.dirStat
+			}
+		} else {
+			if data.IncludeMeta {
+				entry.Contents, entry.Meta = e.file.Contents, e.file.Meta
+			}
+			if data.IncludeStat {
+				entry.Size, entry.fileStat = &size, e.file.stat()
+			}
+		}
+		resp = append(resp, entry)
+	}
+
+	return c.JSON(200, resp)
+}
+
+// readDirSort is the order of the entries of a listing. Equal entries are
+// sorted by path, so that the order is stable across pages.
+type readDirSort struct {
+	// by is name, mtime, size or meta.<field>
+	by   string
+	desc bool
+	// key returns the value an entry is sorted by, nil when sorted by name
+	key func(e listedEntry) (any, bool)
+}
+
+func readDirOrder(by, order string) (readDirSort, error) {
+	o := readDirSort{by: by}
+	switch order {
+	case "", "asc":
+	case "desc":
+		o.desc = true
+	default:
+		return readDirSort{}, echo.NewHTTPError(http.StatusBadRequest, "order: must be asc or desc")
+	}
+
+	switch {
+	case by == "" || by == "name":
+		o.by = "name"
+	case by == "mtime":
+		o.key = func(e listedEntry) (any, bool) {
+			return e.file.ModTime, true
+		}
+	case by == "size":
+		o.key = func(e listedEntry) (any, bool) {
+			return e.file.Size, true
+		}
+	case strings.HasPrefix(by, "meta.") && len(by) > len("meta."):
+		field := strings.Split(strings.TrimPrefix(by, "meta."), ".")
+		o.key = func(e listedEntry) (any, bool) {
+			return metaField(e.file.Meta, field)
+		}
+	default:
+		return readDirSort{}, echo.NewHTTPError(http.StatusBadRequest, "sort: must be name, mtime, size or meta.<field>")
+	}
+	return o, nil
+}
+
+func (o readDirSort) less(a, b listedEntry) bool {
+	if o.key == nil {
+		if o.desc {
+			return a.path > b.path
+		}
+		return a.path < b.path
+	}
+
+	// the entries without the key come last, in any order
+	if a.hasKey != b.hasKey {
+		return a.hasKey
+	}
+	if a.hasKey {
+		cmp := compareValues(a.key, b.key)
+		if o.desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return a.path < b.path
+}
+
+// readDirPage selects the entries of a page while the listing is walked,
+// without sorting the entries it does not return.
+type readDirPage struct {
+	order readDirSort
+	last  *listedEntry
+	limit int
+	// heap holds the first entries after last, the greatest on top when
+	// there is a limit
+	heap []listedEntry
+}
+
+func newReadDirPage(order readDirSort, last *listedEntry, limit int) *readDirPage {
+	return &readDirPage{order: order, last: last, limit: limit}
+}
+
+// add keeps e if it comes after the previous page and among the first
+// limit+1 entries, the extra one telling whether there is a next page.
+func (p *readDirPage) add(e listedEntry) {
+	if p.order.key != nil {
+		e.key, e.hasKey = p.order.key(e)
+	}
+	if p.last != nil && !p.order.less(*p.last, e) {
+		return
+	}
+	if p.limit == 0 {
+		p.heap = append(p.heap, e)
+		return
+	}
+	if len(p.heap) <= p.limit {
+		heap.Push(p, e)
+	} else if p.order.less(e, p.heap[0]) {
+		p.heap[0] = e
+		heap.Fix(p, 0)
+	}
+}
+
+// skips tells whether every path starting with prefix comes before the
+// previous page, so that the directory at prefix need not be walked. It is
+// only known when sorted by name.
+func (p *readDirPage) skips(prefix string) bool {
+	if p.last == nil || p.order.key != nil {
+		return false
+	}
+	if p.order.desc {
+		return p.last.path <= prefix
+	}
+	return p.last.path >= prefix && !strings.HasPrefix(p.last.path, prefix)
+}
+
+// entries returns the entries of the page in order, and whether there are
+// more after them.
+func (p *readDirPage) entries() ([]listedEntry, bool) {
+	res := p.heap
+	sort.Slice(res, func(i, j int) bool {
+		return p.order.less(res[i], res[j])
+	})
+	if p.limit > 0 && len(res) > p.limit {
+		return res[:p.limit], true
+	}
+	return res, false
+}
+
+func (p *readDirPage) Len() int           { return len(p.heap) }
+func (p *readDirPage) Less(i, j int) bool { return p.order.less(p.heap[j], p.heap[i]) }
+func (p *readDirPage) Swap(i, j int)      { p.heap[i], p.heap[j] = p.heap[j], p.heap[i] }
+func (p *readDirPage) Push(x any)         { p.heap = append(p.heap, x.(listedEntry)) }
+func (p *readDirPage) Pop() any {
+	e := p.heap[len(p.heap)-1]
+	p.heap = p.heap[:len(p.heap)-1]
+	return e
+}
+
+// metaField returns the frontmatter field at path, nested maps being walked
+// one key at a time.
+func metaField(meta any, path []string) (any, bool) {
+	v := meta
+	for _, k := range path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok || v == nil {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// compareValues orders frontmatter values: numbers, then times, then strings,
+// then booleans, then anything else by its JSON encoding.
+func compareValues(a, b any) int {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+
+	switch ra {
+	case 0:
+		fa, fb := toFloat(a), toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case 1:
+		return compareTimes(a.(time.Time), b.(time.Time))
+	case 2:
+		return strings.Compare(a.(string), b.(string))
+	case 3:
+		switch {
+		case a == b:
+			return 0
+		case a == false:
+			return -1
+		}
+		return 1
+	}
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return strings.Compare(string(ja), string(jb))
+}
+
+func valueRank(v any) int {
+	switch v.(type) {
+	case int, int64, uint64, float64:
+		return 0
+	case time.Time:
+		return 1
+	case string:
+		return 2
+	case bool:
+		return 3
+	}
+	return 4
+}
+
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
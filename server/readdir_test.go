@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadDirRecursive(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"docs/a.md":        "---\ntitle: B\nweight: 2\n---\naaaa",
+		"docs/b.md":        "---\ntitle: A\nweight: 10\n---\nb",
+		"docs/sub/c.md":    "---\nweight: 1\n---\ncc",
+		"docs/sub/deep/d":  "ddd",
+		"docs/zz/e.md":     "e",
+		"other/ignored.md": "o",
+	})
+
+	type entry struct {
+		Dir  bool   `json:"dir"`
+		Path string `json:"path"`
+	}
+	list := func(query string) string {
+		t.Helper()
+		var entries []entry
+		ts.get(t, "/readdir?d=docs&"+query, &entries)
+		var paths []string
+		for _, e := range entries {
+			paths = append(paths, e.Path)
+		}
+		return strings.Join(paths, " ")
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "a.md b.md sub zz"},
+		{"depth=2", "a.md b.md sub sub/c.md sub/deep zz zz/e.md"},
+		{"depth=-1", "a.md b.md sub sub/c.md sub/deep sub/deep/d zz zz/e.md"},
+		{"order=desc", "zz sub b.md a.md"},
+		{"depth=-1&sort=size", "zz zz/e.md sub/deep sub/deep/d sub/c.md sub b.md a.md"},
+		{"depth=2&sort=meta.weight", "sub/c.md a.md b.md sub sub/deep zz zz/e.md"},
+		{"depth=2&sort=meta.weight&order=desc", "b.md a.md sub/c.md sub sub/deep zz zz/e.md"},
+		{"sort=meta.title", "b.md a.md sub zz"},
+	}
+	for _, test := range tests {
+		if got := list(test.query); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.query, got, test.want)
+		}
+	}
+
+	for _, query := range []string{"depth=0", "depth=-2", "sort=date", "sort=meta.", "order=up", "limit=-1", "cursor=x"} {
+		if rec := ts.request(t, http.MethodGet, "/readdir?d=docs&"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestReadDirPages(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"a.md": "a",
+		"b.md": "b",
+		"c.md": "c",
+		"d.md": "d",
+		"e.md": "e",
+	})
+
+	page := func(cursor string) (string, string) {
+		t.Helper()
+		target := "/readdir?d=/&limit=2"
+		if cursor != "" {
+			target += "&cursor=" + cursor
+		}
+		var entries []struct {
+			Path string `json:"path"`
+		}
+		rec := ts.request(t, http.MethodGet, target, "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		var paths []string
+		for _, e := range entries {
+			paths = append(paths, e.Path)
+		}
+		return strings.Join(paths, " "), rec.Header().Get(headerNextCursor)
+	}
+
+	got, cursor := page("")
+	if got != "a.md b.md" || cursor == "" {
+		t.Fatalf("got %q and cursor %q", got, cursor)
+	}
+
+	// the next pages are read from the version of the first one
+	ts.fs.Remove("a.md")
+	ts.expectChanges(t, "removed a.md")
+	got, cursor = page(cursor)
+	if got != "c.md d.md" || cursor == "" {
+		t.Fatalf("got %q and cursor %q", got, cursor)
+	}
+	got, last := page(cursor)
+	if got != "e.md" || last != "" {
+		t.Fatalf("got %q and cursor %q on the last page", got, last)
+	}
+
+	ts.clock.Advance(ts.SnapshotRetention + time.Second)
+	ts.fs.WriteFile("f.md", "f")
+	ts.expectChanges(t, "created f.md")
+	if rec := ts.request(t, http.MethodGet, "/readdir?d=/&limit=2&cursor="+cursor, ""); rec.Code != http.StatusGone {
+		t.Fatalf("got status %d for an expired cursor, want 410", rec.Code)
+	}
+}
+
+func TestReadDirPagedOrders(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"docs/a.md":       "---\ntitle: B\nweight: 2\n---\naaaa",
+		"docs/b.md":       "---\ntitle: A\nweight: 10\n---\nb",
+		"docs/sub.md":     "---\ntitle: A\nweight: 2.5\n---\nb",
+		"docs/sub/c.md":   "---\nweight: 1\ndate: 2022-01-02\n---\ncc",
+		"docs/sub/deep/d": "ddd",
+		"docs/zz/e.md":    "---\ndate: 2022-01-01\n---\ne",
+	})
+
+	list := func(query string) (string, string) {
+		t.Helper()
+		var entries []struct {
+			Path string `json:"path"`
+		}
+		rec := ts.request(t, http.MethodGet, "/readdir?d=docs&"+query, "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		var paths []string
+		for _, e := range entries {
+			paths = append(paths, e.Path)
+		}
+		return strings.Join(paths, " "), rec.Header().Get(headerNextCursor)
+	}
+
+	// every page starts right after the previous one, whatever the order
+	for _, query := range []string{
+		"depth=-1",
+		"depth=-1&order=desc",
+		"depth=2&sort=size",
+		"depth=-1&sort=size&order=desc",
+		"depth=-1&sort=mtime",
+		"depth=-1&sort=meta.weight",
+		"depth=-1&sort=meta.title&order=desc",
+		"depth=-1&sort=meta.date",
+	} {
+		want, _ := list(query)
+		for limit := 1; limit <= 3; limit++ {
+			var pages []string
+			cursor := ""
+			for i := 0; i < 10; i++ {
+				target := query + "&limit=" + strconv.Itoa(limit)
+				if cursor != "" {
+					target += "&cursor=" + cursor
+				}
+				var got string
+				got, cursor = list(target)
+				pages = append(pages, got)
+				if cursor == "" {
+					break
+				}
+			}
+			if got := strings.Join(pages, " "); got != want || cursor != "" {
+				t.Errorf("%s&limit=%d: got %q, want %q", query, limit, got, want)
+			}
+		}
+	}
+
+	// the cursor is only valid with the parameters of the first page
+	_, cursor := list("depth=-1&sort=size&limit=2")
+	for _, query := range []string{
+		"d=docs/sub&depth=-1&sort=size",
+		"d=docs&depth=2&sort=size",
+		"d=docs&depth=-1&sort=mtime",
+		"d=docs&depth=-1&sort=size&order=desc",
+	} {
+		target := "/readdir?" + query + "&limit=2&cursor=" + cursor
+		if rec := ts.request(t, http.MethodGet, target, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d for a cursor of other parameters, want 400", query, rec.Code)
+		}
+	}
+	if _, next := list("depth=-1&sort=size&order=asc&limit=2&cursor=" + cursor); next == "" {
+		t.Error("no next page with the same parameters")
+	}
+}
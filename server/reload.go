@@ -0,0 +1,312 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// watchConfig starts watching the directory of the config file, editors often
+// replace the file instead of writing to it.
+func (s *FsServer) watchConfig() error {
+	if s.opts.ConfigFile == "" || s.opts.LoadConfig == nil {
+		return nil
+	}
+	abs, err := filepath.Abs(s.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	s.configFile = abs
+	return s.source.Add(filepath.Dir(abs))
+}
+
+func (s *FsServer) isConfigFile(name string) bool {
+	if s.configFile == "" {
+		return false
+	}
+	abs, err := filepath.Abs(name)
+	return err == nil && abs == s.configFile
+}
+
+// reloadConfig reads the configuration again and applies the settings that
+// can change at runtime: ignore rules, frontmatter extensions, CORS, auth and
+// the snapshot retention.
+// The other settings need a restart.
+func (s *FsServer) reloadConfig() error {
+	config, err := s.opts.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	s.configLock.RLock()
+	old := s.Config
+	s.configLock.RUnlock()
+
+	// the FS a root is read from can only be set from Go, it is kept for the
+	// mounts that did not change
+	if config.Base == old.Base {
+		config.FS = old.FS
+	}
+	for i, c := range config.Roots {
+		for _, r := range old.Roots {
+			if normalizePrefix(c.Prefix) == normalizePrefix(r.Prefix) && c.Dir == r.Dir {
+				config.Roots[i].FS = r.FS
+			}
+		}
+	}
+	if err = config.Validate(); err != nil {
+		return err
+	}
+
+	restart := []struct {
+		key     string
+		changed bool
+	}{
+		{"dir and roots", !sameMounts(config.mounts(), old.mounts())},
+		{"bind", config.Bind != old.Bind},
+		{"port", config.Port != old.Port},
+		{"journal_size", config.JournalSize != old.JournalSize},
+		{"rescan_interval", config.RescanInterval != old.RescanInterval},
+		{"workers", config.Workers != old.Workers},
+		{"shutdown_timeout", config.ShutdownTimeout != old.ShutdownTimeout},
+		{"debounce and max_wait", config.Debounce != old.Debounce || config.MaxWait != old.MaxWait},
+		{"watcher", config.Watcher != old.Watcher || config.PollInterval != old.PollInterval},
+	}
+	for _, r := range restart {
+		if r.changed {
+			log.Warnf("config: %s changed, restart the server to apply it", r.key)
+		}
+	}
+
+	// the mounted directories stay the same until a restart, only their
+	// rules are taken from the new config
+	reloaded := config
+	reloaded.Base, reloaded.FS = old.Base, old.FS
+	reloaded.Roots = mergeMountRules(old.Roots, config.Roots)
+	oldMounts, newMounts := old.mounts(), reloaded.mounts()
+
+	var rulesChanged []int
+	parsersChanged := false
+	for i := range oldMounts {
+		o, n := oldMounts[i], newMounts[i]
+		if !reflect.DeepEqual(o.Include, n.Include) || !reflect.DeepEqual(o.Exclude, n.Exclude) || *o.GitIgnore != *n.GitIgnore {
+			rulesChanged = append(rulesChanged, i)
+		}
+		if !reflect.DeepEqual(o.FrontmatterExtensions, n.FrontmatterExtensions) {
+			parsersChanged = true
+		}
+	}
+
+	oldRoots, roots := s.roots, s.roots
+	if len(rulesChanged) > 0 || parsersChanged {
+		roots, err = newRoots(reloaded)
+		if err != nil {
+			return err
+		}
+	}
+
+	var before map[string]bool
+	if parsersChanged {
+		before = map[string]bool{}
+		for k, v := range s.loadedFiles.Copy() {
+			before[k] = s.parsesFrontmatter(v.Path)
+		}
+	}
+
+	s.configLock.Lock()
+	s.Include, s.Exclude, s.GitIgnore = reloaded.Include, reloaded.Exclude, reloaded.GitIgnore
+	s.FrontmatterExtensions = reloaded.FrontmatterExtensions
+	s.Roots = reloaded.Roots
+	s.CorsOrigins = reloaded.CorsOrigins
+	s.AuthTokens = reloaded.AuthTokens
+	s.SnapshotRetention = reloaded.SnapshotRetention
+	s.roots = roots
+	s.configLock.Unlock()
+	s.loadedFiles.SetRetention(reloaded.SnapshotRetention, s.clock.Now)
+
+	if parsersChanged {
+		// only the files that gained or lost their parser are read again
+		var files []string
+		for k, v := range s.loadedFiles.Copy() {
+			if before[k] != s.parsesFrontmatter(v.Path) {
+				files = append(files, v.Path)
+			}
+		}
+		s.updateLock.Lock()
+		for _, entry := range s.loadFiles(files) {
+			s.storeFile(entry)
+		}
+		s.updateLock.Unlock()
+	}
+	if len(rulesChanged) > 0 {
+		added, removed, err := s.applyRules(oldRoots, roots, rulesChanged)
+		if err != nil {
+			return fmt.Errorf("ignore rules: %w", err)
+		}
+		log.Infof("config: ignore rules changed, %d added, %d removed", added, removed)
+	}
+	return nil
+}
+
+// applyRules loads the files and directories of the roots at changed that the
+// new rules include and the old ones ignored, and drops the stored ones the
+// new rules ignore. Only the directories the new rules do not ignore are walked, and
+// only the files whose status changed are read. The walk does not hold the
+// update lock, the events received meanwhile are applied with the new rules.
+func (s *FsServer) applyRules(old, roots []*fsRoot, changed []int) (added, removed int, err error) {
+	var load []string
+	var dirs []fsDirData
+	for _, i := range changed {
+		o, n := old[i], roots[i]
+		// watched is the last directory the old rules ignored, which is now
+		// watched with everything under it
+		watched := ""
+		err = fs.WalkDir(n.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) && p != "." {
+					return nil
+				}
+				return err
+			}
+			if p == "." {
+				return nil
+			}
+			if n.ignore.ignored(p, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			name := filepath.Join(n.dir, filepath.FromSlash(p))
+			under := watched != "" && strings.HasPrefix(p, watched+"/")
+			if !under && !o.ignore.ignored(p, d.IsDir()) {
+				return nil
+			}
+			if !d.IsDir() {
+				load = append(load, name)
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				dirs = append(dirs, newDirData(name, s.relPath(name), info))
+			}
+			if !under {
+				watched = p
+				return s.source.AddRecursive(name)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", n.dir, err)
+		}
+	}
+
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	for k, v := range s.loadedFiles.Copy() {
+		if s.isIgnored(v.Path, false) {
+			s.removeFile(k)
+			removed++
+		}
+	}
+	for _, d := range s.storedDirs() {
+		if s.isIgnored(d.Path, true) {
+			s.removeDir(d.Rel)
+		}
+	}
+	s.storeDirs(dirs)
+	for _, entry := range s.loadFiles(load) {
+		// the watcher may have loaded it after the walk
+		if _, ok := s.loadedFiles.TryGet(entry.Rel); !ok {
+			s.storeFile(entry)
+			added++
+		}
+	}
+	return added, removed, nil
+}
+
+func (s *FsServer) reloadConfigLogged() {
+	if err := s.reloadConfig(); err != nil {
+		log.Warnf("config reload: %v", err)
+		return
+	}
+	log.Infof("config reloaded from %s", s.opts.ConfigFile)
+}
+
+func sameMounts(a, b []RootConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Prefix != b[i].Prefix || a[i].Dir != b[i].Dir {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeMountRules keeps the prefix, directory and FS of the running roots,
+// taking the rules of the root with the same prefix in the new config.
+func mergeMountRules(running, config []RootConfig) []RootConfig {
+	res := make([]RootConfig, len(running))
+	for i, r := range running {
+		res[i] = RootConfig{Prefix: r.Prefix, Dir: r.Dir, FS: r.FS}
+		for _, c := range config {
+			if normalizePrefix(c.Prefix) == normalizePrefix(r.Prefix) {
+				c.Prefix, c.Dir, c.FS = r.Prefix, r.Dir, r.FS
+				res[i] = c
+			}
+		}
+	}
+	return res
+}
+
+func (s *FsServer) corsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		s.configLock.RLock()
+		origins := s.CorsOrigins
+		s.configLock.RUnlock()
+
+		if len(origins) == 0 {
+			return next(c)
+		}
+		return middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:  origins,
+			AllowHeaders:  []string{echo.HeaderAuthorization, echo.HeaderContentType, "Last-Event-ID"},
+			ExposeHeaders: []string{headerSnapshotVersion, headerNextCursor},
+		})(next)(c)
+	}
+}
+
+// authMiddleware accepts a bearer token in the Authorization header or, for
+// EventSource and WebSocket clients that cannot set headers, in ?token=.
+func (s *FsServer) authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		s.configLock.RLock()
+		tokens := s.AuthTokens
+		s.configLock.RUnlock()
+
+		if len(tokens) == 0 {
+			return next(c)
+		}
+
+		token := c.QueryParam("token")
+		if h := c.Request().Header.Get(echo.HeaderAuthorization); strings.HasPrefix(h, "Bearer ") {
+			token = strings.TrimPrefix(h, "Bearer ")
+		}
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return next(c)
+			}
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+	}
+}
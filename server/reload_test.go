@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fs-watcher-server/fake"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	var hook *hookFS
+	ts := newTestServer(t, map[string]string{
+		"a.md":            "a",
+		"b.txt":           "b",
+		"drafts/c.md":     "c",
+		"drafts/sub/d.md": "d",
+		"drafts/sub/.e":   "e",
+	}, func(opts *Options) {
+		opts.Config.Exclude = append(opts.Config.Exclude, "drafts")
+		hook = &hookFS{FS: opts.FS.(*fake.FS)}
+		opts.FS = hook
+	})
+
+	// the config file knows nothing of the FS the root is read from
+	ts.opts.LoadConfig = func() (Config, error) {
+		config := DefaultConfig()
+		config.Base = testDir
+		config.Exclude = append(config.Exclude, "*.txt")
+		config.CorsOrigins = []string{"https://app.example.com"}
+		return config, nil
+	}
+	var lock sync.Mutex
+	var read []string
+	hook.setOnRead(func(name string) {
+		lock.Lock()
+		read = append(read, name)
+		lock.Unlock()
+	})
+	if err := ts.reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	ts.expectChanges(t, "removed b.txt", "created drafts/c.md", "created drafts/sub/d.md")
+
+	// only the files the new rules added were read, and the directory they
+	// include is watched
+	hook.setOnRead(nil)
+	lock.Lock()
+	if got := strings.Join(read, " "); got != "drafts/c.md drafts/sub/d.md" {
+		t.Errorf("read %q", got)
+	}
+	lock.Unlock()
+	watched := ts.source.WatchList()
+	sort.Strings(watched)
+	if got := strings.Join(watched, " "); !strings.Contains(got, testDir+"/drafts") || strings.Contains(got, "drafts/sub") {
+		t.Errorf("watching %q", got)
+	}
+
+	ts.configLock.RLock()
+	root, origins := ts.roots[0], ts.CorsOrigins
+	ts.configLock.RUnlock()
+	if root.fsys != hook || root.osDir || len(origins) != 1 {
+		t.Fatalf("unexpected root after the reload: %+v", root)
+	}
+
+	var files []readFileEntry
+	ts.get(t, "/readFile?f=a.md", &files)
+	if len(files) != 1 || files[0].Contents != "a" {
+		t.Fatalf("unexpected files after the reload: %+v", files)
+	}
+}
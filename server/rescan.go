@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"time"
+)
+
+type rescanResult struct {
+	Added    int           `json:"added"`
+	Updated  int           `json:"updated"`
+	Removed  int           `json:"removed"`
+	Duration time.Duration `json:"duration"`
+}
+
+// rescan walks every root and reconciles the store with what is on disk, healing
+// anything the watcher missed. Files whose size and modification time match the
+// stored entry are not read again. The update worker waits for it, so that
+// the events received meanwhile are applied on top of what it stored. Once it
+// succeeds, the overflows that happened before it started are healed.
+func (s *FsServer) rescan() (res rescanResult, err error) {
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	start := s.clock.Now()
+	overflows := s.overflowsBefore()
+
+	var files []string
+	var dirs []fsDirData
+	for _, dir := range s.rootDirs() {
+		// re-adding a watch is a no-op, this only picks up directories created
+		// in the window before their parent was watched
+		if err = s.source.AddRecursive(dir); err != nil {
+			return res, fmt.Errorf("watcher: %w", err)
+		}
+		rootFiles, rootDirs, err := s.walkFiles(dir)
+		if err != nil {
+			return res, fmt.Errorf("walk: %w", err)
+		}
+		files = append(files, rootFiles...)
+		dirs = append(dirs, rootDirs...)
+	}
+
+	seen := map[string]struct{}{}
+	var load []string
+	for _, f := range files {
+		rel := s.relPath(f)
+		seen[rel] = struct{}{}
+
+		if old, ok := s.loadedFiles.TryGet(rel); ok {
+			stat, err := s.stat(f)
+			if err == nil && stat.Size() == old.Size && stat.ModTime().Equal(old.ModTime) {
+				continue
+			}
+		}
+		load = append(load, f)
+	}
+
+	for _, entry := range s.loadFiles(load) {
+		old, exists := s.loadedFiles.TryGet(entry.Rel)
+		switch {
+		case !exists:
+			res.Added++
+		case !journaled(old, entry):
+			// only the stat changed, there is nothing to tell the clients
+			s.loadedFiles.Set(entry.Rel, entry)
+			continue
+		default:
+			res.Updated++
+		}
+		s.storeFile(entry)
+	}
+
+	for k, v := range s.loadedFiles.Copy() {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		// the watcher may have loaded it after the walk
+		if stat, err := s.stat(v.Path); err == nil && !stat.IsDir() && !s.isIgnored(v.Path, false) {
+			continue
+		}
+		s.removeFile(k)
+		res.Removed++
+	}
+
+	// the directories are stored once their files are
+	s.storeDirs(dirs)
+	seenDirs := map[string]struct{}{}
+	for _, d := range dirs {
+		seenDirs[d.Rel] = struct{}{}
+	}
+	for _, d := range s.storedDirs() {
+		if _, ok := seenDirs[d.Rel]; ok {
+			continue
+		}
+		if _, ok := s.loadDir(d.Path); !ok {
+			s.removeDir(d.Rel)
+		}
+	}
+
+	s.healOverflows(overflows)
+	res.Duration = s.clock.Now().Sub(start)
+	return res, nil
+}
+
+func (s *FsServer) rescanLogged(reason string) {
+	res, err := s.rescan()
+	if err != nil {
+		log.Warnf("rescan (%s): %v", reason, err)
+		return
+	}
+	log.Infof("rescan (%s): %d added, %d updated, %d removed in %v", reason, res.Added, res.Updated, res.Removed, res.Duration)
+}
+
+func (s *FsServer) handleRescan(c echo.Context) error {
+	res, err := s.rescan()
+	if err != nil {
+		return err
+	}
+	return c.JSON(200, res)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fs-watcher-server/fake"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookFS calls onStat after a file is stat'ed, onRead after it is read and
+// onReadDir after a directory is listed, before the results are returned.
+type hookFS struct {
+	*fake.FS
+
+	lock      sync.Mutex
+	onStat    func(name string)
+	onRead    func(name string)
+	onReadDir func(name string)
+}
+
+func (f *hookFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := f.FS.ReadDir(name)
+	f.lock.Lock()
+	onReadDir := f.onReadDir
+	f.lock.Unlock()
+	if onReadDir != nil {
+		onReadDir(name)
+	}
+	return entries, err
+}
+
+func (f *hookFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.FS.Stat(name)
+	f.lock.Lock()
+	onStat := f.onStat
+	f.lock.Unlock()
+	if onStat != nil {
+		onStat(name)
+	}
+	return info, err
+}
+
+func (f *hookFS) ReadFile(name string) ([]byte, error) {
+	data, err := f.FS.ReadFile(name)
+	f.lock.Lock()
+	onRead := f.onRead
+	f.lock.Unlock()
+	if onRead != nil {
+		onRead(name)
+	}
+	return data, err
+}
+
+func (f *hookFS) setOnStat(onStat func(name string)) {
+	f.lock.Lock()
+	f.onStat = onStat
+	f.lock.Unlock()
+}
+
+func (f *hookFS) setOnRead(onRead func(name string)) {
+	f.lock.Lock()
+	f.onRead = onRead
+	f.lock.Unlock()
+}
+
+func (f *hookFS) setOnReadDir(onReadDir func(name string)) {
+	f.lock.Lock()
+	f.onReadDir = onReadDir
+	f.lock.Unlock()
+}
+
+func TestRescanOrdering(t *testing.T) {
+	var hook *hookFS
+	ts := newTestServer(t, map[string]string{"a.md": "v1"}, func(opts *Options) {
+		hook = &hookFS{FS: opts.FS.(*fake.FS)}
+		opts.FS = hook
+	})
+
+	// the watcher misses a change that the rescan finds
+	ts.source.Drop(true)
+	ts.fs.WriteFile("a.md", "v2 missed")
+	ts.source.Drop(false)
+
+	read, release := make(chan struct{}), make(chan struct{})
+	hook.setOnRead(func(name string) {
+		hook.setOnRead(nil)
+		close(read)
+		<-release
+	})
+	rescanned := make(chan error)
+	go func() {
+		_, err := ts.rescan()
+		rescanned <- err
+	}()
+	<-read
+
+	// the file changes again while the rescan holds what it read, the event
+	// must be applied after the rescan stored it
+	ts.fs.WriteFile("a.md", "v3")
+	for i := 0; i < 10; i++ {
+		ts.clock.Advance(ts.MaxWait)
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if err := <-rescanned; err != nil {
+		t.Fatal(err)
+	}
+	ts.expectChanges(t, "updated a.md", "updated a.md")
+
+	var files []readFileEntry
+	ts.get(t, "/readFile?f=a.md", &files)
+	if len(files) != 1 || files[0].Contents != "v3" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+}
+
+func TestRescanVanishingDir(t *testing.T) {
+	var hook *hookFS
+	ts := newTestServer(t, map[string]string{
+		"a.md":         "a",
+		"tmp/b.md":     "b",
+		"tmp/sub/c.md": "c",
+	}, func(opts *Options) {
+		hook = &hookFS{FS: opts.FS.(*fake.FS)}
+		opts.FS = hook
+	})
+
+	// tmp is removed between the listing of the root and its own, as during
+	// a checkout
+	ts.source.Drop(true)
+	hook.setOnReadDir(func(name string) {
+		if name == "." {
+			hook.setOnReadDir(nil)
+			ts.fs.Remove("tmp")
+		}
+	})
+	res, err := ts.rescan()
+	ts.source.Drop(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Removed != 2 {
+		t.Fatalf("got %+v, want 2 removed", res)
+	}
+	if _, ok := ts.loadedFiles.TryGet("a.md"); !ok {
+		t.Fatal("a.md removed")
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RootConfig mounts a directory under a prefix of the served namespace. The
+// ignore rules and frontmatter extensions default to the global ones.
+type RootConfig struct {
+	Prefix                string   `yaml:"prefix" toml:"prefix"`
+	Dir                   string   `yaml:"dir" toml:"dir"`
+	Include               []string `yaml:"include" toml:"include"`
+	Exclude               []string `yaml:"exclude" toml:"exclude"`
+	GitIgnore             *bool    `yaml:"gitignore" toml:"gitignore"`
+	FrontmatterExtensions []string `yaml:"frontmatter_extensions" toml:"frontmatter_extensions"`
+
+	// FS is read instead of Dir, which is then only used to map the names of
+	// the watcher events to the root.
+	FS fs.FS `yaml:"-" toml:"-"`
+}
+
+// fsRoot is a mounted directory with its settings resolved.
+type fsRoot struct {
+	prefix      string
+	dir         string
+	fsys        fs.FS
+	osDir       bool
+	frontmatter []string
+	ignore      *ignoreRules
+}
+
+// mounts returns the roots described by the config: either the single Base
+// directory mounted at the top, or Roots with the global defaults applied.
+func (c *Config) mounts() []RootConfig {
+	roots := c.Roots
+	if len(roots) == 0 {
+		roots = []RootConfig{{Dir: c.Base, FS: c.FS}}
+	}
+
+	res := make([]RootConfig, len(roots))
+	for i, r := range roots {
+		r.Prefix = normalizePrefix(r.Prefix)
+		if r.Include == nil {
+			r.Include = c.Include
+		}
+		if r.Exclude == nil {
+			r.Exclude = c.Exclude
+		}
+		if r.GitIgnore == nil {
+			gitIgnore := c.GitIgnore
+			r.GitIgnore = &gitIgnore
+		}
+		if r.FrontmatterExtensions == nil {
+			r.FrontmatterExtensions = c.FrontmatterExtensions
+		}
+		res[i] = r
+	}
+	return res
+}
+
+func newRoots(c Config) ([]*fsRoot, error) {
+	var roots []*fsRoot
+	for _, m := range c.mounts() {
+		dir, err := filepath.Abs(m.Dir)
+		if err != nil {
+			return nil, err
+		}
+
+		fsys := m.FS
+		if fsys == nil {
+			fsys = os.DirFS(dir)
+		}
+
+		ignore, err := newIgnoreRules(fsys, m.Include, m.Exclude, *m.GitIgnore)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.Dir, err)
+		}
+		roots = append(roots, &fsRoot{
+			prefix:      m.Prefix,
+			dir:         dir,
+			fsys:        fsys,
+			osDir:       m.FS == nil,
+			frontmatter: m.FrontmatterExtensions,
+			ignore:      ignore,
+		})
+	}
+	return roots, nil
+}
+
+// rootOf finds the root containing name, and the slash-separated path of name
+// relative to it. root is nil if name is outside every root.
+func (s *FsServer) rootOf(name string) (root *fsRoot, rel string) {
+	s.configLock.RLock()
+	roots := s.roots
+	s.configLock.RUnlock()
+
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, ""
+	}
+	for _, r := range roots {
+		if abs != r.dir && !strings.HasPrefix(abs, r.dir+string(filepath.Separator)) {
+			continue
+		}
+		if root == nil || len(r.dir) > len(root.dir) {
+			root = r
+		}
+	}
+	if root == nil {
+		return nil, ""
+	}
+
+	rel, _ = filepath.Rel(root.dir, abs)
+	return root, filepath.ToSlash(rel)
+}
+
+// relPath returns the path of a file in the served namespace: its path
+// relative to its root, under the root prefix.
+func (s *FsServer) relPath(fileName string) string {
+	root, rel := s.rootOf(fileName)
+	if root == nil {
+		return ""
+	}
+	if rel == "." {
+		return strings.TrimSuffix(root.prefix, "/")
+	}
+	return root.prefix + rel
+}
+
+func (s *FsServer) isIgnored(name string, isDir bool) bool {
+	root, rel := s.rootOf(name)
+	if root == nil {
+		return true
+	}
+	return root.ignore.ignored(rel, isDir)
+}
+
+func (s *FsServer) isOutside(name string) bool {
+	root, _ := s.rootOf(name)
+	return root == nil
+}
+
+func (s *FsServer) parsesFrontmatter(fileName string) bool {
+	root, _ := s.rootOf(fileName)
+	return root != nil && hasExtension(root.frontmatter, fileName)
+}
+
+// invalidateIgnore drops the cached ignore files of the directory of name, it
+// returns true if the ignore rules of the root may have changed.
+func (s *FsServer) invalidateIgnore(name string) bool {
+	root, rel := s.rootOf(name)
+	return root != nil && root.ignore.invalidate(rel)
+}
+
+func (s *FsServer) rootDirs() []string {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+
+	res := make([]string, len(s.roots))
+	for i, r := range s.roots {
+		res[i] = r.dir
+	}
+	return res
+}
+
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(filepath.ToSlash(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+func hasExtension(exts []string, fileName string) bool {
+	ext := filepath.Ext(fileName)
+	for _, e := range exts {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often a long running load logs its progress.
+const progressInterval = 5 * time.Second
+
+// A failed initial scan is retried after initialScanRetry, doubled after each
+// failure up to maxInitialScanRetry.
+const (
+	initialScanRetry    = time.Second
+	maxInitialScanRetry = time.Minute
+)
+
+// loadFiles runs loadFile over files on a pool of s.Workers goroutines. The
+// entries are returned in the order of files, without the ones that could not
+// be loaded.
+func (s *FsServer) loadFiles(files []string) []fsFileData {
+	if len(files) == 0 {
+		return nil
+	}
+
+	entries := make([]fsFileData, len(files))
+	loaded := make([]bool, len(files))
+	var next, done int64
+
+	workers := s.Workers
+	if workers > len(files) {
+		workers = len(files)
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1) - 1)
+				if i >= len(files) {
+					return
+				}
+				entries[i], loaded[i] = s.loadFile(files[i])
+				atomic.AddInt64(&done, 1)
+			}
+		}()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	ticker, stopTicker := s.clock.NewTicker(progressInterval)
+	defer stopTicker()
+	for waiting := true; waiting; {
+		select {
+		case <-finished:
+			waiting = false
+		case <-ticker:
+			log.Infof("loading: %d/%d files", atomic.LoadInt64(&done), len(files))
+		}
+	}
+
+	res := entries[:0]
+	for i, entry := range entries {
+		if loaded[i] {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+// initialScan loads every root, the server is ready once it succeeded. It is
+// retried until then, or until the server is shut down. The watcher events
+// received meanwhile are applied afterwards.
+func (s *FsServer) initialScan() {
+	delay := initialScanRetry
+	for {
+		res, err := s.rescan()
+		s.setScanError(err)
+		if err == nil {
+			log.Infof("initial scan: loaded %d files in %v", res.Added, res.Duration)
+			close(s.ready)
+			return
+		}
+		log.Warnf("initial scan: %v, retrying in %v", err, delay)
+
+		timer, stopTimer := s.clock.NewTimer(delay)
+		select {
+		case <-timer:
+		case <-s.done:
+			stopTimer()
+			return
+		}
+		if delay *= 2; delay > maxInitialScanRetry {
+			delay = maxInitialScanRetry
+		}
+	}
+}
+
+// waitReady waits up to ReadyWait for the initial scan, it returns false if
+// the scan is still running.
+func (s *FsServer) waitReady(ctx context.Context) bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+	}
+	if s.ReadyWait <= 0 {
+		return false
+	}
+
+	timer, stopTimer := s.clock.NewTimer(s.ReadyWait)
+	defer stopTimer()
+	select {
+	case <-s.ready:
+		return true
+	case <-timer:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// readyMiddleware answers 503 to the requests that cannot be served until the
+// initial scan is done.
+func (s *FsServer) readyMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !s.waitReady(c.Request().Context()) {
+			c.Response().Header().Set("Retry-After", "1")
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "initial scan in progress")
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fs-watcher-server/fake"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadFiles(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("f%02d.txt", i)] = fmt.Sprint(i)
+	}
+	ts := newTestServer(t, files)
+	ts.Workers = 4
+
+	var names []string
+	for i := 49; i >= 0; i-- {
+		names = append(names, ts.fs.Path(fmt.Sprintf("f%02d.txt", i)))
+		if i%10 == 0 {
+			names = append(names, ts.fs.Path("missing.txt"), ts.fs.Path(".hidden"))
+		}
+	}
+
+	entries := ts.loadFiles(names)
+	if len(entries) != 50 {
+		t.Fatalf("loaded %d files, want 50", len(entries))
+	}
+	for i, entry := range entries {
+		if want := fmt.Sprint(49 - i); entry.Contents != want {
+			t.Fatalf("entry %d: got %q, want %q", i, entry.Contents, want)
+		}
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	clock := fake.NewClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewFsServer(Options{Config: DefaultConfig(), Clock: clock})
+	handler := s.Handler()
+
+	// the initial scan never ran, so the server is not ready
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readFile?f=a.md", nil))
+		return rec
+	}
+	if rec := get(); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("got status %d, want 503 with Retry-After", rec.Code)
+	}
+
+	s.ReadyWait = time.Minute
+	go func() {
+		for clock.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Minute)
+	}()
+	if rec := get(); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d after waiting, want 503", rec.Code)
+	}
+
+	go func() {
+		for clock.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		close(s.ready)
+	}()
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d once ready, want 200", rec.Code)
+	}
+}
+
+// failingFS fails to list directories while failing is set, counting the
+// failures.
+type failingFS struct {
+	*fake.FS
+
+	lock     sync.Mutex
+	failing  bool
+	failures int
+}
+
+func (f *failingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f.lock.Lock()
+	failing := f.failing
+	if failing {
+		f.failures++
+	}
+	f.lock.Unlock()
+	if failing {
+		return nil, errors.New("input/output error")
+	}
+	return f.FS.ReadDir(name)
+}
+
+func (f *failingFS) failed() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.failures
+}
+
+func (f *failingFS) setFailing(failing bool) {
+	f.lock.Lock()
+	f.failing = failing
+	f.lock.Unlock()
+}
+
+func TestInitialScanRetry(t *testing.T) {
+	clock := fake.NewClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	source := fake.NewWatcher()
+	fsys := &failingFS{FS: fake.NewFS(testDir, source, clock), failing: true}
+	fsys.WriteFile("a.md", "a")
+
+	config := DefaultConfig()
+	config.Base = testDir
+	config.FS = fsys
+	s := NewFsServer(Options{Config: config, Source: source, Clock: clock})
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	// a failed scan does not make the server ready
+	deadline := time.Now().Add(5 * time.Second)
+	for rec := get("/readyz"); !strings.Contains(rec.Body.String(), "initial scan failed"); rec = get("/readyz") {
+		if time.Now().After(deadline) {
+			t.Fatalf("scan failure not reported: %s", rec.Body)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if rec := get("/readFile?f=a.md"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d after a failed scan, want 503", rec.Code)
+	}
+
+	// it is retried until it succeeds
+	fsys.setFailing(false)
+	timeout := time.After(5 * time.Second)
+	for ready := false; !ready; {
+		select {
+		case <-s.Ready():
+			ready = true
+		case <-time.After(time.Millisecond):
+			clock.Advance(initialScanRetry)
+		case <-timeout:
+			t.Fatal("not ready after the retries")
+		}
+	}
+	if rec := get("/readFile?f=a.md"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d once ready, want 200", rec.Code)
+	}
+}
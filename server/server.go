@@ -0,0 +1,687 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fs-watcher-server/utils"
+	"github.com/adrg/frontmatter"
+	"github.com/fsnotify/fsnotify"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configure a FsServer. Config holds the settings that can also come
+// from a config file, the other fields are only available to Go code.
+type Options struct {
+	Config
+
+	// ConfigFile is watched for changes, which are read with LoadConfig.
+	ConfigFile string
+	LoadConfig func() (Config, error)
+
+	// Source and Clock default to the watcher selected by Config.Watcher and
+	// the system clock.
+	Source EventSource
+	Clock  Clock
+}
+
+// FsServer holds the files of its roots in memory, keeps them up to date with
+// a watcher and serves them over HTTP.
+type FsServer struct {
+	Config
+
+	opts  Options
+	clock Clock
+
+	done    chan bool
+	ready   chan struct{}
+	closing chan struct{}
+	stopped chan struct{}
+	state   serverState
+
+	web          *echo.Echo
+	lifecycle    sync.Mutex
+	started      bool
+	shuttingDown bool
+
+	metrics *serverMetrics
+
+	source      EventSource
+	loadedFiles *utils.RWMap[string, fsFileData]
+	index       *dirIndex
+	changes     *changeBroker
+	roots       []*fsRoot
+	configLock  sync.RWMutex
+	configFile  string
+
+	// updateLock is held by the rescans and by the update worker while it
+	// applies a batch, the store has a single writer at a time
+	updateLock sync.Mutex
+}
+
+type fsFileData struct {
+	Path     string
+	Rel      string
+	Contents string
+	Size     int64
+	ModTime  time.Time
+	Mode     fs.FileMode
+	Symlink  bool
+	Hash     string
+	Meta     any
+}
+
+func NewFsServer(opts Options) *FsServer {
+	s := &FsServer{
+		Config: opts.Config,
+		opts:   opts,
+		clock:  opts.Clock,
+
+		done:        make(chan bool),
+		ready:       make(chan struct{}),
+		closing:     make(chan struct{}),
+		stopped:     make(chan struct{}),
+		loadedFiles: utils.NewRWMap[string, fsFileData](),
+		index:       newDirIndex(),
+	}
+	s.loadedFiles.OnChange(s.index.update)
+	s.loadedFiles.OnPrune(s.index.prune)
+	if s.clock == nil {
+		s.clock = realClock{}
+	}
+	s.changes = newChangeBroker(s.JournalSize, s.clock)
+	s.loadedFiles.SetRetention(s.SnapshotRetention, s.clock.Now)
+	s.metrics = newServerMetrics(s)
+	s.web = s.newEcho()
+	s.loadedFiles.OnReprBuilt(func(d time.Duration) {
+		s.metrics.reprBuild.Observe(d.Seconds())
+	})
+	return s
+}
+
+func (s *FsServer) loadFile(fileName string) (fsFileData, bool) {
+	stat, err := s.stat(fileName)
+	if err != nil || stat.IsDir() || s.isIgnored(fileName, false) {
+		return fsFileData{}, false
+	}
+
+	data, err := s.readFile(fileName)
+	if err != nil {
+		return fsFileData{}, false
+	}
+
+	hash := sha256.Sum256(data)
+	entry := fsFileData{
+		Path:     fileName,
+		Rel:      s.relPath(fileName),
+		Contents: string(data),
+		Size:     int64(len(data)),
+		ModTime:  stat.ModTime(),
+		Mode:     stat.Mode(),
+		Hash:     hex.EncodeToString(hash[:]),
+	}
+	if lstat, err := s.lstat(fileName); err == nil {
+		entry.Symlink = lstat.Mode()&fs.ModeSymlink != 0
+	}
+	if s.parsesFrontmatter(fileName) {
+		var matter map[any]any
+		_, err := frontmatter.Parse(bytes.NewReader(data), &matter)
+		if err != nil {
+			log.Warnf("frontmatter: %v", err)
+			s.metrics.parseErrors.WithLabelValues("frontmatter").Inc()
+		} else {
+			entry.Meta = utils.YamlToJson(matter)
+		}
+	}
+	s.metrics.filesLoaded.Inc()
+	return entry, true
+}
+
+func (s *FsServer) updateFile(fileName string) {
+	entry, ok := s.loadFile(fileName)
+	if !ok {
+		return
+	}
+	s.storeFile(entry)
+}
+
+func (s *FsServer) storeFile(entry fsFileData) {
+	op := OpCreated
+	if old, ok := s.loadedFiles.TryGet(entry.Rel); ok {
+		if !journaled(old, entry) {
+			s.loadedFiles.Set(entry.Rel, entry)
+			return
+		}
+		op = OpUpdated
+	}
+	s.loadedFiles.Set(entry.Rel, entry)
+	s.changes.publish(Change{Op: op, Path: entry.Rel, Hash: entry.Hash, Meta: entry.Meta})
+}
+
+func (s *FsServer) removeFile(file string) {
+	if _, ok := s.loadedFiles.TryGet(file); !ok {
+		return
+	}
+	s.loadedFiles.Delete(file)
+	s.metrics.filesRemoved.Inc()
+	s.changes.publish(Change{Op: OpRemoved, Path: file})
+}
+
+func (s *FsServer) moveFile(oldFile string, entry fsFileData) {
+	s.loadedFiles.Move(oldFile, entry.Rel, entry)
+	s.changes.publish(Change{Op: OpRenamed, Path: entry.Rel, OldPath: oldFile, Hash: entry.Hash, Meta: entry.Meta})
+}
+
+// filesUnder returns the keys of every loaded file at or below the given key.
+func (s *FsServer) filesUnder(file string) (res []string) {
+	s.loadedFiles.View(utils.Latest, func(utils.View[string, fsFileData]) {
+		res = s.index.under(file)
+	})
+	return res
+}
+
+// removePath drops a removed file, or a removed directory with everything
+// under it.
+func (s *FsServer) removePath(name string) {
+	if s.exists(name) {
+		return
+	}
+	key := s.relPath(name)
+	for _, f := range s.filesUnder(key) {
+		s.removeFile(f)
+	}
+	s.removeDir(key)
+}
+
+// renamePath moves the entries of oldName to newName, it returns false if the
+// two names cannot be paired and must be handled as a remove and a create.
+func (s *FsServer) renamePath(oldName, newName string) bool {
+	if s.exists(oldName) {
+		return false
+	}
+	stat, err := s.stat(newName)
+	if err != nil || s.isIgnored(newName, stat.IsDir()) {
+		return false
+	}
+	oldKey, newKey := s.relPath(oldName), s.relPath(newName)
+
+	if !stat.IsDir() {
+		if _, ok := s.loadedFiles.TryGet(oldKey); !ok {
+			return false
+		}
+		entry, ok := s.loadFile(newName)
+		if !ok {
+			return false
+		}
+		s.moveFile(oldKey, entry)
+		return true
+	}
+
+	files, dirs, err := s.walkFiles(newName)
+	if err != nil {
+		log.Warnf("rename %s: %v", newKey, err)
+	}
+	for _, f := range files {
+		entry, ok := s.loadFile(f)
+		if !ok {
+			continue
+		}
+		oldFile := oldKey + strings.TrimPrefix(entry.Rel, newKey)
+		if _, ok := s.loadedFiles.TryGet(oldFile); ok {
+			s.moveFile(oldFile, entry)
+		} else {
+			s.storeFile(entry)
+		}
+	}
+	for _, f := range s.filesUnder(oldKey) {
+		s.removeFile(f)
+	}
+	s.removeDir(oldKey)
+	s.storeDirs(dirs)
+	return true
+}
+
+// applyEvents reconciles the store with a batch of watcher events. A Rename
+// immediately followed by a Create is how fsnotify reports a move within the
+// watched tree, so the two are paired into a single rename. Editors saving
+// atomically write a temporary file and move it over the original, or move the
+// original away and write a new one: in both cases the final name exists when
+// the batch is applied and it is simply re-read.
+func (s *FsServer) applyEvents(events []fsnotify.Event) {
+	rulesChanged := false
+	defer func() {
+		if rulesChanged {
+			go s.rescanLogged("ignore files changed")
+		}
+	}()
+
+	// the directories of the events are stated once the files are stored, a
+	// new directory is stored and the stat of the parent of a change is
+	// updated
+	touched := map[string]struct{}{}
+	for _, e := range events {
+		touched[e.Name], touched[filepath.Dir(e.Name)] = struct{}{}, struct{}{}
+	}
+	defer func() {
+		var dirs []fsDirData
+		for name := range touched {
+			if d, ok := s.loadDir(name); ok {
+				dirs = append(dirs, d)
+			}
+		}
+		s.storeDirs(dirs)
+	}()
+
+	// consecutive creates and writes are read in parallel, the other events
+	// wait for them to keep the order of the changes
+	var updates []string
+	updating := map[string]bool{}
+	flush := func() {
+		for _, entry := range s.loadFiles(updates) {
+			s.storeFile(entry)
+		}
+		updates = nil
+		updating = map[string]bool{}
+	}
+	defer flush()
+
+	for i := 0; i < len(events); i++ {
+		e := events[i]
+		log.Infof("[CHANGE] %s", e)
+
+		if s.invalidateIgnore(e.Name) {
+			rulesChanged = true
+		}
+
+		if e.Op&(fsnotify.Rename|fsnotify.Remove) == 0 && e.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+			// the contents of a new directory are reported as creates too
+			if !updating[e.Name] {
+				updating[e.Name] = true
+				updates = append(updates, e.Name)
+			}
+			continue
+		}
+		flush()
+
+		switch {
+		case e.Op&fsnotify.Rename != 0:
+			if i+1 < len(events) && events[i+1].Op&fsnotify.Create != 0 && events[i+1].Name != e.Name {
+				newName := events[i+1].Name
+				if _, ok := s.loadedFiles.TryGet(s.relPath(newName)); ok {
+					// a file was moved over an existing one
+					s.removePath(e.Name)
+					s.updateFile(newName)
+					i++
+					continue
+				}
+				if s.renamePath(e.Name, newName) {
+					i++
+					continue
+				}
+			}
+			s.removePath(e.Name)
+
+		case e.Op&fsnotify.Remove != 0:
+			s.removePath(e.Name)
+		}
+	}
+}
+
+func (s *FsServer) startWatcher() {
+	batches := make(chan eventBatch)
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		s.applyBatches(batches)
+	}()
+	defer func() {
+		// the batch being applied is finished, the pending ones are dropped
+		close(batches)
+		<-workerDone
+		close(s.stopped)
+	}()
+
+	s.setWatching(true)
+	defer s.setWatching(false)
+
+	debounce := debouncer{quiet: s.Debounce, maxWait: s.MaxWait}
+	var queue eventQueue
+	reload := false
+	var timer <-chan time.Time
+	stopTimer := func() bool { return false }
+	defer func() { stopTimer() }()
+
+	// ready is the batch waiting for the worker, send is nil while it is
+	// empty so that the loop never blocks on a busy worker
+	var ready eventBatch
+	var send chan<- eventBatch
+
+	prune, stopPrune := s.clock.NewTicker(pruneInterval)
+	defer stopPrune()
+
+	var rescan <-chan time.Time
+	if s.RescanInterval > 0 {
+		var stopRescan func()
+		rescan, stopRescan = s.clock.NewTicker(s.RescanInterval)
+		defer stopRescan()
+	}
+
+	for {
+		select {
+		case <-timer:
+			timer = nil
+			if wait := debounce.wait(s.clock.Now()); wait > 0 {
+				timer, stopTimer = s.clock.NewTimer(wait)
+				continue
+			}
+			debounce.reset()
+			events, received := queue.take()
+			ready.merge(eventBatch{events: events, received: received, reload: reload})
+			reload = false
+			send = batches
+
+		case send <- ready:
+			ready = eventBatch{}
+			send = nil
+
+		case e, ok := <-s.source.Events():
+			if !ok {
+				log.Warnf("watcher: closed, changes are no longer tracked")
+				return
+			}
+			s.eventReceived()
+			s.metrics.eventReceived(e)
+			configChanged := s.isConfigFile(e.Name) && e.Op&(fsnotify.Create|fsnotify.Write) != 0
+			if configChanged {
+				reload = true
+			}
+			if !s.isOutside(e.Name) {
+				queue.add(e, s.clock.Now())
+			} else if !configChanged {
+				continue
+			}
+			debounce.add(s.clock.Now())
+			if timer == nil {
+				timer, stopTimer = s.clock.NewTimer(debounce.wait(s.clock.Now()))
+			}
+
+		case <-rescan:
+			go s.rescanLogged("interval")
+
+		case <-prune:
+			s.loadedFiles.Prune()
+
+		case e, ok := <-s.source.Errors():
+			if !ok {
+				log.Warnf("watcher: closed, changes are no longer tracked")
+				return
+			}
+			log.Warnf("watcher: %v", e)
+			if errors.Is(e, fsnotify.ErrEventOverflow) {
+				s.overflowed()
+				go s.rescanLogged("overflow")
+			}
+
+		case <-s.done:
+			_ = s.source.Close()
+			return
+		}
+	}
+}
+
+// walkFiles lists every file under path that is not ignored, and the stat of
+// every directory, path included if it is one. What is removed during the
+// walk is skipped, as happens during a checkout, only path itself has to
+// exist.
+func (s *FsServer) walkFiles(path string) (files []string, dirs []fsDirData, err error) {
+	err = s.walkDir(path, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && walkPath != path {
+				return nil
+			}
+			return err
+		}
+		if s.isIgnored(walkPath, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			files = append(files, walkPath)
+		} else if info, err := d.Info(); err == nil {
+			dirs = append(dirs, newDirData(walkPath, s.relPath(walkPath), info))
+		}
+		return nil
+	})
+	return files, dirs, err
+}
+
+func (s *FsServer) watchFilter(walkPath string, d fs.DirEntry) bool {
+	return !s.isIgnored(walkPath, d.IsDir())
+}
+
+// Start calls Init and serves HTTP on Bind and Port until Shutdown is
+// called, it returns nil after a graceful shutdown.
+func (s *FsServer) Start() error {
+	err := s.Init()
+	if err == nil {
+		err = s.web.Start(fmt.Sprintf("%s:%d", s.Bind, s.Port))
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the server: the SSE and WebSocket clients are disconnected,
+// the other requests are drained until ctx is done, then the watcher is
+// stopped once the changes being applied are stored. It can be called more
+// than once.
+func (s *FsServer) Shutdown(ctx context.Context) error {
+	s.lifecycle.Lock()
+	if s.shuttingDown {
+		s.lifecycle.Unlock()
+		return nil
+	}
+	s.shuttingDown = true
+	started := s.started
+	s.lifecycle.Unlock()
+
+	close(s.closing)
+	err := s.web.Shutdown(ctx)
+	close(s.done)
+	if !started {
+		return err
+	}
+
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// Init starts watching the roots and loads them in the background, see
+// Ready. It does not serve HTTP, which embedders do with Handler.
+func (s *FsServer) Init() (err error) {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	if s.shuttingDown {
+		return http.ErrServerClosed
+	}
+	if s.started {
+		return errors.New("server already started")
+	}
+
+	if err = s.Validate(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	s.state.started = s.clock.Now()
+	s.roots, err = newRoots(s.Config)
+	if err != nil {
+		return fmt.Errorf("roots: %w", err)
+	}
+
+	s.source = s.opts.Source
+	if s.source == nil {
+		s.source, err = s.newEventSource()
+		if err != nil {
+			return fmt.Errorf("watcher: %w", err)
+		}
+	}
+	for _, dir := range s.rootDirs() {
+		err = s.source.AddRecursive(dir)
+		if err != nil {
+			return fmt.Errorf("watcher: %w", err)
+		}
+	}
+	if err = s.watchConfig(); err != nil {
+		return fmt.Errorf("watch config: %w", err)
+	}
+
+	s.started = true
+	go s.startWatcher()
+	go s.initialScan()
+	return nil
+}
+
+func (s *FsServer) newEcho() *echo.Echo {
+	e := echo.New()
+	e.Use(s.metricsMiddleware, s.corsMiddleware, s.snapshotMiddleware)
+
+	// the probes answer without a token, and during the initial scan
+	e.GET("/healthz", s.handleHealthz)
+	e.GET("/readyz", s.handleReadyz)
+	e.GET("/status", s.handleStatus, s.authMiddleware)
+	e.GET("/metrics", s.handleMetrics, s.authMiddleware)
+
+	g := e.Group("", s.authMiddleware, s.readyMiddleware)
+	g.Match([]string{"GET", "POST"}, "/readFile", s.handleReadFile)
+	g.Match([]string{"GET", "POST"}, "/readdir", s.handleReadDir)
+	g.Match([]string{"GET", "POST"}, "/stat", s.handleStat)
+	g.Match([]string{"GET", "POST"}, "/all", s.handleAll)
+	g.GET("/events", s.handleEvents)
+	g.GET("/ws", s.handleWs)
+	g.GET("/changes", s.handleChanges)
+	g.POST("/admin/rescan", s.handleRescan)
+	return e
+}
+
+func (s *FsServer) handleAll(c echo.Context) (err error) {
+	if c.QueryParam("at") == "" {
+		repr, version := s.loadedFiles.GetRepr()
+		setSnapshotVersion(c, version)
+		return c.String(200, repr)
+	}
+
+	// older versions are not cached, they are marshalled on every request
+	files := map[string]fsFileData{}
+	err = s.viewFiles(c, func(view utils.View[string, fsFileData]) {
+		view.Range(func(k string, v fsFileData) bool {
+			files[k] = v
+			return true
+		})
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	return c.String(200, string(data))
+}
+
+func (s *FsServer) handleChanges(c echo.Context) (err error) {
+	var data struct {
+		Since uint64 `query:"since"`
+	}
+
+	err = c.Bind(&data)
+	if err != nil {
+		return
+	}
+
+	changes, head, ok := s.changes.since(data.Since)
+	if !ok {
+		return c.JSON(http.StatusGone, map[string]any{
+			"resync_required": true,
+			"seq":             head,
+		})
+	}
+	if changes == nil {
+		changes = []Change{}
+	}
+
+	return c.JSON(200, map[string]any{
+		"seq":     head,
+		"changes": changes,
+	})
+}
+
+// viewIndex calls f with the files at version at and their index, like
+// viewFilesAt. The index is read at the version of the view.
+func (s *FsServer) viewIndex(c echo.Context, at uint64, f func(view utils.View[string, fsFileData], index *dirIndex)) error {
+	return s.viewFilesAt(c, at, func(view utils.View[string, fsFileData]) {
+		f(view, s.index)
+	})
+}
+
+func (s *FsServer) handleReadFile(c echo.Context) (err error) {
+	var data struct {
+		File  string   `query:"f" json:"file"`
+		Files []string `json:"files"`
+	}
+
+	err = c.Bind(&data)
+	if err != nil {
+		return
+	}
+
+	if data.File != "" {
+		data.Files = append(data.Files, data.File)
+	}
+
+	if len(data.Files) == 0 {
+		return fmt.Errorf("no files requested")
+	}
+
+	type respEntry struct {
+		Path     string `json:"path"`
+		Contents string `json:"contents"`
+		Meta     any    `json:"meta,omitempty"`
+	}
+
+	resp := make([]respEntry, 0, len(data.Files))
+	err = s.viewFiles(c, func(view utils.View[string, fsFileData]) {
+		for _, f := range data.Files {
+			if file, ok := view.TryGet(f); ok {
+				resp = append(resp, respEntry{
+					Path:     file.Rel,
+					Contents: string(file.Contents),
+					Meta:     file.Meta,
+				})
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(200, resp)
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fs-watcher-server/fake"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDir = "/fake/root"
+
+type testServer struct {
+	*FsServer
+	fs      *fake.FS
+	source  *fake.Watcher
+	clock   *fake.Clock
+	handler http.Handler
+	events  chan Change
+}
+
+// newTestServer starts a server over an in-memory root holding files, the
+// options can be changed by setup before it starts.
+func newTestServer(t *testing.T, files map[string]string, setup ...func(opts *Options)) *testServer {
+	t.Helper()
+
+	clock := fake.NewClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	source := fake.NewWatcher()
+	fsys := fake.NewFS(testDir, source, clock)
+	for name, contents := range files {
+		fsys.WriteFile(name, contents)
+	}
+
+	config := DefaultConfig()
+	config.Base = testDir
+	config.FS = fsys
+
+	opts := Options{Config: config, Source: source, Clock: clock}
+	for _, f := range setup {
+		f(&opts)
+	}
+	s := NewFsServer(opts)
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	<-s.Ready()
+	events, _, _, _ := s.changes.subscribe(0, false)
+	t.Cleanup(func() {
+		s.changes.unsubscribe(events)
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return &testServer{
+		FsServer: s,
+		fs:       fsys,
+		source:   source,
+		clock:    clock,
+		handler:  s.web,
+		events:   events,
+	}
+}
+
+func (ts *testServer) request(t *testing.T, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ts *testServer) get(t *testing.T, target string, v any) {
+	t.Helper()
+
+	rec := ts.request(t, http.MethodGet, target, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", target, rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: %v", target, err)
+	}
+}
+
+// expectChanges lets time pass until the next changes are published, and
+// checks them against want.
+func (ts *testServer) expectChanges(t *testing.T, want ...string) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for _, w := range want {
+		var e Change
+		for received := false; !received; {
+			select {
+			case e = <-ts.events:
+				received = true
+			case <-time.After(time.Millisecond):
+				ts.clock.Advance(ts.Debounce)
+			case <-timeout:
+				t.Fatalf("timed out waiting for change %q", w)
+			}
+		}
+
+		got := string(e.Op) + " " + e.Path
+		if e.OldPath != "" {
+			got = string(e.Op) + " " + e.OldPath + " -> " + e.Path
+		}
+		if got != w {
+			t.Fatalf("got change %q, want %q", got, w)
+		}
+	}
+}
+
+type readFileEntry struct {
+	Path     string         `json:"path"`
+	Contents string         `json:"contents"`
+	Meta     map[string]any `json:"meta"`
+}
+
+func TestReadFile(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"a.md":       "---\ntitle: A\n---\nhello",
+		"b/c.txt":    "c",
+		".hidden":    "h",
+		"backup.md~": "b",
+	})
+
+	var files []readFileEntry
+	ts.get(t, "/readFile?f=a.md", &files)
+	if len(files) != 1 || files[0].Path != "a.md" || files[0].Contents != "---\ntitle: A\n---\nhello" {
+		t.Fatalf("unexpected response: %+v", files)
+	}
+	if files[0].Meta["title"] != "A" {
+		t.Fatalf("unexpected meta: %+v", files[0].Meta)
+	}
+
+	rec := ts.request(t, http.MethodPost, "/readFile", `{"files":["b/c.txt","missing",".hidden","backup.md~"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	files = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &files); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "b/c.txt" || files[0].Meta != nil {
+		t.Fatalf("unexpected response: %+v", files)
+	}
+
+	rec = ts.request(t, http.MethodGet, "/readFile", "")
+	if rec.Code == http.StatusOK {
+		t.Fatalf("expected an error without files, got %s", rec.Body)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"docs/a.md":     "---\ntitle: A\n---\n",
+		"docs/sub/b.md": "b",
+		"other.md":      "o",
+	})
+
+	var entries []struct {
+		Dir      bool           `json:"dir"`
+		Path     string         `json:"path"`
+		Contents string         `json:"contents"`
+		Meta     map[string]any `json:"meta"`
+	}
+	ts.get(t, "/readdir?d=/docs/&m=1", &entries)
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if a := entries[0]; a.Dir || a.Path != "a.md" || a.Meta["title"] != "A" {
+		t.Fatalf("unexpected file entry: %+v", a)
+	}
+	if sub := entries[1]; !sub.Dir || sub.Path != "sub" || sub.Contents != "" {
+		t.Fatalf("unexpected dir entry: %+v", sub)
+	}
+
+	rec := ts.request(t, http.MethodGet, "/readdir", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 without dir, got %d", rec.Code)
+	}
+}
+
+func TestAll(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"a.md":    "a",
+		"b/c.txt": "c",
+	})
+
+	var all map[string]fsFileData
+	ts.get(t, "/all", &all)
+	if len(all) != 2 || all["a.md"].Contents != "a" || all["b/c.txt"].Path != testDir+"/b/c.txt" {
+		t.Fatalf("unexpected files: %+v", all)
+	}
+
+	// the representation follows the changes
+	ts.fs.WriteFile("a.md", "a2")
+	ts.fs.Remove("b/c.txt")
+	ts.expectChanges(t, "updated a.md", "removed b/c.txt")
+	all = nil
+	ts.get(t, "/all", &all)
+	if len(all) != 1 || all["a.md"].Contents != "a2" {
+		t.Fatalf("unexpected files after the changes: %+v", all)
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"a.md": "a",
+	})
+
+	ts.fs.WriteFile("b.md", "b")
+	ts.fs.WriteFile("a.md", "a2")
+	ts.expectChanges(t, "created b.md", "updated a.md")
+
+	// writing the same contents is not a change
+	ts.fs.WriteFile("a.md", "a2")
+	ts.fs.WriteFile(".hidden", "h")
+	ts.fs.Remove("b.md")
+	ts.expectChanges(t, "removed b.md")
+
+	ts.fs.Rename("a.md", "c.md")
+	ts.expectChanges(t, "renamed a.md -> c.md")
+
+	var files []readFileEntry
+	ts.get(t, "/readFile?f=c.md", &files)
+	if len(files) != 1 || files[0].Contents != "a2" {
+		t.Fatalf("unexpected response: %+v", files)
+	}
+}
+
+func TestApplyDirectoryChanges(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"docs/a.md":     "a",
+		"docs/sub/b.md": "b",
+	})
+
+	ts.fs.Rename("docs", "notes")
+	ts.expectChanges(t, "renamed docs/a.md -> notes/a.md", "renamed docs/sub/b.md -> notes/sub/b.md")
+
+	ts.fs.WriteFile("new/c.md", "c")
+	ts.expectChanges(t, "created new/c.md")
+
+	ts.fs.Remove("notes")
+	ts.expectChanges(t, "removed notes/sub/b.md", "removed notes/a.md")
+
+	var changes struct {
+		Seq     uint64   `json:"seq"`
+		Changes []Change `json:"changes"`
+	}
+	ts.get(t, "/changes?since=4", &changes)
+	if changes.Seq != 7 || len(changes.Changes) != 3 || changes.Changes[0].Path != "new/c.md" {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+}
+
+func TestWsOrigin(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	check := func(origin string) bool {
+		req := httptest.NewRequest(http.MethodGet, "http://fs.example.com/ws", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		return ts.checkWsOrigin(req)
+	}
+
+	// without cors_origins only the same origin is accepted
+	if !check("") || !check("https://fs.example.com") || check("https://evil.example.com") {
+		t.Fatal("unexpected origins accepted without cors_origins")
+	}
+
+	ts.configLock.Lock()
+	ts.CorsOrigins = []string{"https://app.example.com"}
+	ts.configLock.Unlock()
+	if !check("https://app.example.com") || check("https://fs.example.com") || check("https://evil.example.com") {
+		t.Fatal("unexpected origins accepted with cors_origins")
+	}
+}
+
+func TestWsSubscribe(t *testing.T) {
+	ts := newTestServer(t, nil)
+	srv := httptest.NewServer(ts.handler)
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	send := func(typ string, patterns ...string) {
+		t.Helper()
+		if err := conn.WriteJSON(wsRequest{Type: typ, Patterns: patterns}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// expect reads the next message as "<type> <patterns or change path or error>"
+	expect := func(want string) {
+		t.Helper()
+		var resp wsResponse
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatalf("waiting for %q: %v", want, err)
+		}
+		got := resp.Type + " " + strings.Join(resp.Patterns, ",") + resp.Error
+		if resp.Change != nil {
+			got = resp.Type + " " + resp.Change.Path
+		}
+		if got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	write := func(name string) {
+		t.Helper()
+		ts.fs.WriteFile(name, name)
+		ts.expectChanges(t, "created "+name)
+	}
+
+	send("subscribe", "docs/**/*.md")
+	expect("subscribed docs/**/*.md")
+	send("subscribe", "[")
+	expect("error invalid pattern: [")
+	send("watch", "docs/**")
+	expect("error unknown message type: watch")
+
+	// only the changes matching the patterns are sent
+	write("other.md")
+	write("docs/a.txt")
+	write("docs/a.md")
+	write("docs/sub/b.md")
+	expect("change docs/a.md")
+	expect("change docs/sub/b.md")
+
+	// after unsubscribing nothing is sent until another subscription matches
+	send("unsubscribe", "docs/**/*.md")
+	expect("unsubscribed ")
+	write("docs/c.md")
+	send("subscribe", "other/*")
+	expect("subscribed other/*")
+	write("other/d.md")
+	expect("change other/d.md")
+}
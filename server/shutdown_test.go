@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"a.md": "a"})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.web.Listener = ln
+	ts.web.HideBanner, ts.web.HidePort = true, true
+	served := make(chan error, 1)
+	go func() {
+		served <- ts.web.Start("")
+	}()
+	url := "http://" + ln.Addr().String()
+
+	resp, err := http.Get(url + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ts.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("server stopped with %v", err)
+	}
+
+	// the event stream ends
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("event stream: %v", err)
+	}
+
+	// the websocket is closed with going away
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("websocket: got %v, want a going away close", err)
+	}
+
+	// and the watcher is stopped
+	select {
+	case <-ts.stopped:
+	default:
+		t.Fatal("watcher still running after shutdown")
+	}
+	reasons := strings.Join(ts.notReadyReasons(), ", ")
+	if !strings.Contains(reasons, "watcher not running") {
+		t.Fatalf("got not ready reasons %q", reasons)
+	}
+	if err := ts.Shutdown(ctx); err != nil {
+		t.Fatalf("second shutdown: %v", err)
+	}
+}
+
+func TestShutdownBeforeStart(t *testing.T) {
+	s := NewFsServer(Options{Config: DefaultConfig()})
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("start after shutdown: %v", err)
+	}
+}
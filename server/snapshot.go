@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"fs-watcher-server/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// headerSnapshotVersion carries the version of the files a response was read
+// from. Passing it back as ?at= reads the same version again, for as long as
+// it is retained.
+const headerSnapshotVersion = "X-Snapshot-Version"
+
+// pruneInterval is how often the expired versions are dropped, when no change
+// to the files did it in the meantime.
+const pruneInterval = 10 * time.Second
+
+// snapshotMiddleware sets the latest version on every response, the read
+// endpoints replace it with the version they read.
+func (s *FsServer) snapshotMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		setSnapshotVersion(c, s.loadedFiles.Version())
+		return next(c)
+	}
+}
+
+func setSnapshotVersion(c echo.Context, version uint64) {
+	c.Response().Header().Set(headerSnapshotVersion, strconv.FormatUint(version, 10))
+}
+
+// snapshotAt returns the version asked for with ?at=, or utils.Latest.
+func snapshotAt(c echo.Context) (uint64, error) {
+	at := c.QueryParam("at")
+	if at == "" {
+		return utils.Latest, nil
+	}
+	version, err := strconv.ParseUint(at, 10, 64)
+	if err != nil || version == utils.Latest {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "invalid at")
+	}
+	return version, nil
+}
+
+// viewFiles calls f with the files at the version asked for by the request,
+// and sets the version on the response.
+func (s *FsServer) viewFiles(c echo.Context, f func(files utils.View[string, fsFileData])) error {
+	at, err := snapshotAt(c)
+	if err != nil {
+		return err
+	}
+	return s.viewFilesAt(c, at, f)
+}
+
+// viewFilesAt calls f with the files at version at, which may be utils.Latest,
+// and sets the version on the response.
+func (s *FsServer) viewFilesAt(c echo.Context, at uint64, f func(files utils.View[string, fsFileData])) error {
+	version, err := s.loadedFiles.View(at, f)
+	switch {
+	case errors.Is(err, utils.ErrVersionExpired):
+		return echo.NewHTTPError(http.StatusGone, "snapshot no longer retained")
+	case errors.Is(err, utils.ErrVersionUnknown):
+		return echo.NewHTTPError(http.StatusNotFound, "unknown snapshot version")
+	case err != nil:
+		return err
+	}
+	setSnapshotVersion(c, version)
+	return nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSnapshots(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"docs/a.md": "a",
+		"docs/b.md": "b",
+	})
+
+	rec := ts.request(t, http.MethodGet, "/readdir?d=docs&m=1", "")
+	version := rec.Header().Get(headerSnapshotVersion)
+	if rec.Code != http.StatusOK || version == "" {
+		t.Fatalf("got status %d and version %q", rec.Code, version)
+	}
+
+	ts.fs.WriteFile("docs/a.md", "a2")
+	ts.fs.Rename("docs/b.md", "docs/c.md")
+	ts.expectChanges(t, "updated docs/a.md", "renamed docs/b.md -> docs/c.md")
+
+	// the old version is still readable
+	var files []readFileEntry
+	ts.get(t, "/readFile?f=docs/a.md&at="+version, &files)
+	if len(files) != 1 || files[0].Contents != "a" {
+		t.Fatalf("unexpected files at %s: %+v", version, files)
+	}
+	var all map[string]fsFileData
+	ts.get(t, "/all?at="+version, &all)
+	if len(all) != 2 || all["docs/b.md"].Contents != "b" {
+		t.Fatalf("unexpected files at %s: %+v", version, all)
+	}
+
+	rec = ts.request(t, http.MethodGet, "/readFile?f=docs/a.md", "")
+	latest, _ := strconv.ParseUint(rec.Header().Get(headerSnapshotVersion), 10, 64)
+	if old, _ := strconv.ParseUint(version, 10, 64); latest <= old {
+		t.Fatalf("got version %d after the changes, want more than %d", latest, old)
+	}
+	if rec := ts.request(t, http.MethodGet, "/all?at="+strconv.FormatUint(latest+1, 10), ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("got status %d for a future version, want 404", rec.Code)
+	}
+	if rec := ts.request(t, http.MethodGet, "/all?at=x", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d for an invalid version, want 400", rec.Code)
+	}
+
+	// once the retention is over the old version is gone, even without a
+	// change to drop it
+	ts.clock.Advance(ts.SnapshotRetention + time.Second)
+	if rec := ts.request(t, http.MethodGet, "/readFile?f=docs/a.md&at="+version, ""); rec.Code != http.StatusGone {
+		t.Fatalf("got status %d for an expired version, want 410", rec.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fs-watcher-server/watcher"
+	"github.com/fsnotify/fsnotify"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EventSource delivers file system events for the roots, recursively, with the
+// semantics of watcher.RWatcher.
+type EventSource interface {
+	Add(name string) error
+	AddRecursive(name string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	WatchList() []string
+	Close() error
+}
+
+// Clock is the source of time of FsServer. NewTicker and NewTimer behave like
+// time.NewTicker and time.NewTimer, returning the channel and the Stop
+// function.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type rwatcherSource struct {
+	*watcher.RWatcher
+}
+
+func (w rwatcherSource) Events() <-chan fsnotify.Event {
+	return w.RWatcher.Events
+}
+
+func (w rwatcherSource) Errors() <-chan error {
+	return w.RWatcher.Errors
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+func (realClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	t := time.NewTimer(d)
+	return t.C, t.Stop
+}
+
+// newEventSource creates the watcher configured by s.Watcher.
+func (s *FsServer) newEventSource() (EventSource, error) {
+	var backend watcher.Backend
+	var err error
+	if s.Watcher == watcherPoll {
+		backend, err = watcher.NewPollBackend(s.PollInterval)
+	} else {
+		backend, err = watcher.NewFsnotifyBackend()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rwatcherSource{watcher.NewWatcherWithBackend(backend, s.watchFilter)}, nil
+}
+
+// stat and the other file system helpers take the OS name of a file, as
+// reported by the watcher, and read it from the fs.FS of its root.
+func (s *FsServer) stat(name string) (fs.FileInfo, error) {
+	root, rel := s.rootOf(name)
+	if root == nil {
+		return nil, fs.ErrNotExist
+	}
+	return fs.Stat(root.fsys, rel)
+}
+
+// lstat is stat without following name if it is a symlink. The roots read
+// from an fs.FS have no symlinks.
+func (s *FsServer) lstat(name string) (fs.FileInfo, error) {
+	root, rel := s.rootOf(name)
+	if root == nil {
+		return nil, fs.ErrNotExist
+	}
+	if root.osDir {
+		return os.Lstat(filepath.Join(root.dir, filepath.FromSlash(rel)))
+	}
+	return fs.Stat(root.fsys, rel)
+}
+
+func (s *FsServer) readFile(name string) ([]byte, error) {
+	root, rel := s.rootOf(name)
+	if root == nil {
+		return nil, fs.ErrNotExist
+	}
+	return fs.ReadFile(root.fsys, rel)
+}
+
+func (s *FsServer) exists(name string) bool {
+	_, err := s.stat(name)
+	return err == nil
+}
+
+// walkDir is filepath.WalkDir over the fs.FS of the root containing name.
+func (s *FsServer) walkDir(name string, fn func(walkPath string, d fs.DirEntry, err error) error) error {
+	root, rel := s.rootOf(name)
+	if root == nil {
+		return fs.ErrNotExist
+	}
+	return fs.WalkDir(root.fsys, rel, func(p string, d fs.DirEntry, err error) error {
+		return fn(filepath.Join(root.dir, filepath.FromSlash(p)), d, err)
+	})
+}
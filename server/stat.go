@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fs-watcher-server/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// fileStat is the stat information of a file taken when it was loaded, or of
+// a directory when it was seen, without a hash. Mode is the mode of the target
+// of a symlink.
+type fileStat struct {
+	ModTime time.Time `json:"mod_time"`
+	Mode    string    `json:"mode"`
+	Symlink bool      `json:"symlink,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+func (d fsFileData) stat() *fileStat {
+	return &fileStat{
+		ModTime: d.ModTime,
+		Mode:    d.Mode.String(),
+		Symlink: d.Symlink,
+		Hash:    d.Hash,
+	}
+}
+
+// statEntry is a file, or a directory with the number of files under it and
+// their total size. A directory only known from its files has no stat.
+type statEntry struct {
+	Path  string `json:"path"`
+	Dir   bool   `json:"dir"`
+	Size  int64  `json:"size"`
+	Files int    `json:"files,omitempty"`
+	*fileStat
+}
+
+func (s *FsServer) handleStat(c echo.Context) (err error) {
+	var data struct {
+		File  string   `query:"f" json:"file"`
+		Files []string `json:"files"`
+	}
+
+	err = c.Bind(&data)
+	if err != nil {
+		return
+	}
+
+	if data.File != "" {
+		data.Files = append(data.Files, data.File)
+	}
+
+	if len(data.Files) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "no files requested")
+	}
+
+	at, err := snapshotAt(c)
+	if err != nil {
+		return err
+	}
+
+	// the paths that do not exist are left out, like for /readFile
+	resp := make([]statEntry, 0, len(data.Files))
+	err = s.viewIndex(c, at, func(view utils.View[string, fsFileData], index *dirIndex) {
+		for _, f := range data.Files {
+			key := strings.Trim(f, "/")
+			if file, ok := view.TryGet(key); ok {
+				resp = append(resp, statEntry{Path: key, Size: file.Size, fileStat: file.stat()})
+			} else if dir := index.lookup(key, view.Version()); dir != nil {
+				stat := dir.at(view.Version())
+				entry := statEntry{Path: key, Dir: true, Size: stat.size, Files: stat.fileCount}
+				if stat.dir != nil {
+					entry.fileStat = stat.dir.stat()
+				}
+				resp = append(resp, entry)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(200, resp)
+}
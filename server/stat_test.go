@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"fs-watcher-server/fake"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStat(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"a.md":          "aa",
+		"docs/b.md":     "b",
+		"docs/empty.md": "",
+		"docs/sub/c.md": "ccc",
+	})
+
+	type stat struct {
+		Path    string     `json:"path"`
+		Dir     bool       `json:"dir"`
+		Size    int64      `json:"size"`
+		Files   int        `json:"files"`
+		ModTime *time.Time `json:"mod_time"`
+		Mode    string     `json:"mode"`
+		Hash    string     `json:"hash"`
+	}
+	var stats []stat
+	ts.get(t, "/stat?f=a.md", &stats)
+	if len(stats) != 1 || stats[0].Size != 2 || stats[0].ModTime == nil || stats[0].Mode == "" || stats[0].Hash == "" {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	rec := ts.request(t, http.MethodPost, "/stat", `{"files":["/docs/","missing.md","docs/sub/c.md"]}`)
+	stats = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if len(stats) != 2 || stats[0].Path != "docs" || stats[1].Path != "docs/sub/c.md" {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if !stats[0].Dir || stats[0].Files != 3 || stats[0].Size != 4 || stats[0].ModTime == nil || stats[0].Mode != "drwxr-xr-x" || stats[0].Hash != "" {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// /readdir only includes the stat information when asked to
+	var entries []stat
+	ts.get(t, "/readdir?d=docs&s=1", &entries)
+	if b := entries[0]; b.Size != 1 || b.ModTime == nil || b.Hash == "" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	var raw []map[string]any
+	ts.get(t, "/readdir?d=docs&s=1", &raw)
+	if size, ok := raw[1]["size"]; raw[1]["path"] != "empty.md" || !ok || size != 0.0 {
+		t.Fatalf("no size for an empty file: %+v", raw[1])
+	}
+	raw = nil
+	ts.get(t, "/readdir?d=docs&m=1", &raw)
+	if _, ok := raw[0]["size"]; ok || raw[0]["mod_time"] != nil {
+		t.Fatalf("unexpected entries without stat: %+v", raw)
+	}
+}
+
+func TestLoadSymlink(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "target.md"), []byte("target"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "target.md"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target.md", filepath.Join(dir, "link.md")); err != nil {
+		t.Skip(err)
+	}
+
+	config := DefaultConfig()
+	config.Base = dir
+	s := NewFsServer(Options{Config: config})
+	roots, err := newRoots(s.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.roots = roots
+
+	link, ok := s.loadFile(filepath.Join(dir, "link.md"))
+	if !ok || !link.Symlink || link.Contents != "target" || link.Size != 6 || !link.Mode.IsRegular() {
+		t.Fatalf("unexpected link: %+v", link)
+	}
+	if target, ok := s.loadFile(filepath.Join(dir, "target.md")); !ok || target.Symlink || target.Mode.Perm() != 0o644 {
+		t.Fatalf("unexpected target: %+v", target)
+	}
+}
+
+func TestStatSizeOfRead(t *testing.T) {
+	var hook *hookFS
+	ts := newTestServer(t, map[string]string{"a.md": "a"}, func(opts *Options) {
+		hook = &hookFS{FS: opts.FS.(*fake.FS)}
+		opts.FS = hook
+	})
+
+	// the file grows between its stat and its read, the size must be the one
+	// of the contents served
+	ts.source.Drop(true)
+	hook.setOnStat(func(name string) {
+		hook.setOnStat(nil)
+		ts.fs.WriteFile("a.md", "a grown")
+	})
+	entry, ok := ts.loadFile(ts.fs.Path("a.md"))
+	ts.source.Drop(false)
+	if !ok || entry.Contents != "a grown" || entry.Size != int64(len(entry.Contents)) {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serverState is what the probes and /status report about the watcher.
+// Overflows are numbered, events were lost since the last one until a rescan
+// that started after it succeeds.
+type serverState struct {
+	lock      sync.Mutex
+	started   time.Time
+	watching  bool
+	overflows uint64
+	healed    uint64
+	scanErr   error
+	lastEvent time.Time
+}
+
+func (s *FsServer) setWatching(watching bool) {
+	s.state.lock.Lock()
+	s.state.watching = watching
+	s.state.lock.Unlock()
+}
+
+// overflowed records that events were lost.
+func (s *FsServer) overflowed() {
+	s.state.lock.Lock()
+	s.state.overflows++
+	s.state.lock.Unlock()
+}
+
+// overflowsBefore returns the number of the last overflow, a rescan starting
+// now heals it and those before it.
+func (s *FsServer) overflowsBefore() uint64 {
+	s.state.lock.Lock()
+	defer s.state.lock.Unlock()
+	return s.state.overflows
+}
+
+// healOverflows records that a rescan covering the overflows up to n
+// succeeded.
+func (s *FsServer) healOverflows(n uint64) {
+	s.state.lock.Lock()
+	if n > s.state.healed {
+		s.state.healed = n
+	}
+	s.state.lock.Unlock()
+}
+
+// setScanError records why the last attempt of the initial scan failed, nil
+// once it succeeded.
+func (s *FsServer) setScanError(err error) {
+	s.state.lock.Lock()
+	s.state.scanErr = err
+	s.state.lock.Unlock()
+}
+
+func (s *FsServer) eventReceived() {
+	now := s.clock.Now()
+	s.state.lock.Lock()
+	s.state.lastEvent = now
+	s.state.lock.Unlock()
+}
+
+func (s *FsServer) isReady() bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// notReadyReasons explains why /readyz fails, it is empty when the server is
+// ready.
+func (s *FsServer) notReadyReasons() []string {
+	s.state.lock.Lock()
+	defer s.state.lock.Unlock()
+
+	var reasons []string
+	if !s.isReady() {
+		if s.state.scanErr != nil {
+			reasons = append(reasons, fmt.Sprintf("initial scan failed: %v, retrying", s.state.scanErr))
+		} else {
+			reasons = append(reasons, "initial scan in progress")
+		}
+	}
+	if !s.state.watching {
+		reasons = append(reasons, "watcher not running")
+	}
+	if s.state.overflows > s.state.healed {
+		reasons = append(reasons, "watcher events lost, rescan pending")
+	}
+	return reasons
+}
+
+func (s *FsServer) handleHealthz(c echo.Context) error {
+	return c.String(http.StatusOK, "ok")
+}
+
+func (s *FsServer) handleReadyz(c echo.Context) error {
+	if reasons := s.notReadyReasons(); len(reasons) > 0 {
+		return c.JSON(http.StatusServiceUnavailable, map[string]any{
+			"ready":   false,
+			"reasons": reasons,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"ready": true})
+}
+
+func (s *FsServer) handleStatus(c echo.Context) error {
+	type statusResponse struct {
+		Ready         bool       `json:"ready"`
+		Files         int        `json:"files"`
+		Bytes         int64      `json:"bytes"`
+		WatchedDirs   int        `json:"watched_dirs"`
+		LastEvent     *time.Time `json:"last_event"`
+		Seq           uint64     `json:"seq"`
+		Subscribers   int        `json:"subscribers"`
+		StartedAt     time.Time  `json:"started_at"`
+		UptimeSeconds float64    `json:"uptime_seconds"`
+	}
+
+	resp := statusResponse{Ready: len(s.notReadyReasons()) == 0}
+	resp.Files, resp.Bytes = s.storeSize()
+	if s.source != nil {
+		resp.WatchedDirs = len(s.source.WatchList())
+	}
+	if s.changes != nil {
+		resp.Seq, resp.Subscribers = s.changes.stats()
+	}
+
+	s.state.lock.Lock()
+	if !s.state.lastEvent.IsZero() {
+		lastEvent := s.state.lastEvent
+		resp.LastEvent = &lastEvent
+	}
+	resp.StartedAt = s.state.started
+	s.state.lock.Unlock()
+	resp.UptimeSeconds = s.clock.Now().Sub(resp.StartedAt).Seconds()
+
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fs-watcher-server/fake"
+	"github.com/fsnotify/fsnotify"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProbes(t *testing.T) {
+	config := DefaultConfig()
+	config.AuthTokens = []string{"secret"}
+	s := NewFsServer(Options{
+		Config: config,
+		Clock:  fake.NewClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)),
+	})
+	handler := s.Handler()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	// the probes need no token and answer before the initial scan
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Fatalf("/healthz: got status %d", rec.Code)
+	}
+	rec := get("/readyz")
+	var readyz struct {
+		Ready   bool     `json:"ready"`
+		Reasons []string `json:"reasons"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &readyz); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || readyz.Ready || len(readyz.Reasons) != 2 {
+		t.Fatalf("/readyz: got status %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := get("/status"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("/status: got status %d without a token, want 401", rec.Code)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ts := newTestServer(t, map[string]string{
+		"a.md":    "a",
+		"b/c.txt": "cc",
+	})
+
+	rec := ts.request(t, http.MethodGet, "/readyz", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/readyz: got status %d: %s", rec.Code, rec.Body)
+	}
+
+	type statusResponse struct {
+		Ready         bool       `json:"ready"`
+		Files         int        `json:"files"`
+		Bytes         int64      `json:"bytes"`
+		WatchedDirs   int        `json:"watched_dirs"`
+		LastEvent     *time.Time `json:"last_event"`
+		Seq           uint64     `json:"seq"`
+		Subscribers   int        `json:"subscribers"`
+		UptimeSeconds float64    `json:"uptime_seconds"`
+	}
+	var status statusResponse
+	ts.get(t, "/status", &status)
+	want := statusResponse{Ready: true, Files: 2, Bytes: 3, WatchedDirs: 1, Seq: 2, Subscribers: 1}
+	if status != want {
+		t.Fatalf("got status %+v, want %+v", status, want)
+	}
+
+	ts.fs.WriteFile("d.md", "ddd")
+	ts.expectChanges(t, "created d.md")
+	ts.clock.Advance(time.Minute)
+
+	status = statusResponse{}
+	ts.get(t, "/status", &status)
+	if status.Files != 3 || status.Bytes != 6 || status.Seq != 3 || status.LastEvent == nil || status.UptimeSeconds < 60 {
+		t.Fatalf("unexpected status after a change: %+v", status)
+	}
+}
+
+func TestOverflowRescan(t *testing.T) {
+	ts := newTestServer(t, map[string]string{"a.md": "a"})
+
+	// the events are lost, the rescan finds the changes
+	ts.source.Drop(true)
+	ts.fs.WriteFile("b.md", "b")
+	ts.fs.Remove("a.md")
+	ts.source.Drop(false)
+	ts.source.EmitError(fsnotify.ErrEventOverflow)
+	ts.expectChanges(t, "created b.md", "removed a.md")
+	ts.waitHealthy(t)
+}
+
+func TestOverflowGenerations(t *testing.T) {
+	var hook *hookFS
+	ts := newTestServer(t, map[string]string{"a.md": "a"}, func(opts *Options) {
+		hook = &hookFS{FS: opts.FS.(*fake.FS)}
+		opts.FS = hook
+	})
+
+	// each rescan stops on the file it reloads
+	blocked := map[string]chan struct{}{"a.md": make(chan struct{}), "b.md": make(chan struct{})}
+	release := map[string]chan struct{}{"a.md": make(chan struct{}), "b.md": make(chan struct{})}
+	var once sync.Map
+	hook.setOnRead(func(name string) {
+		if _, done := once.LoadOrStore(name, true); !done && blocked[name] != nil {
+			close(blocked[name])
+			<-release[name]
+		}
+	})
+
+	// a second overflow during the recovery rescan is not healed by it
+	loseEvents(ts, "a.md", "a2")
+	<-blocked["a.md"]
+	loseEvents(ts, "b.md", "b")
+	close(release["a.md"])
+	<-blocked["b.md"]
+	if ts.isHealthy(t) {
+		t.Fatal("ready before the second overflow was rescanned")
+	}
+	close(release["b.md"])
+	ts.expectChanges(t, "updated a.md", "created b.md")
+	ts.waitHealthy(t)
+}
+
+func TestOverflowFailedRescan(t *testing.T) {
+	var failing *failingFS
+	ts := newTestServer(t, map[string]string{"a.md": "a"}, func(opts *Options) {
+		failing = &failingFS{FS: opts.FS.(*fake.FS)}
+		opts.FS = failing
+	})
+
+	// a failed recovery is healed by the next rescan, whatever started it
+	failing.setFailing(true)
+	loseEvents(ts, "b.md", "b")
+	for failing.failed() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	failing.setFailing(false)
+	if ts.isHealthy(t) {
+		t.Fatal("ready after a failed recovery")
+	}
+	if rec := ts.request(t, http.MethodPost, "/admin/rescan", ""); rec.Code != http.StatusOK {
+		t.Fatalf("/admin/rescan: got status %d: %s", rec.Code, rec.Body)
+	}
+	ts.expectChanges(t, "created b.md")
+	ts.waitHealthy(t)
+}
+
+// loseEvents writes a file without the watcher reporting it, then reports the
+// overflow.
+func loseEvents(ts *testServer, name, contents string) {
+	ts.source.Drop(true)
+	ts.fs.WriteFile(name, contents)
+	ts.source.Drop(false)
+	ts.source.EmitError(fsnotify.ErrEventOverflow)
+}
+
+func (ts *testServer) isHealthy(t *testing.T) bool {
+	return ts.request(t, http.MethodGet, "/readyz", "").Code == http.StatusOK
+}
+
+func (ts *testServer) waitHealthy(t *testing.T) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !ts.isHealthy(t) {
+		if time.Now().After(deadline) {
+			t.Fatal("not ready after the rescan")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
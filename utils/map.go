@@ -3,91 +3,247 @@ package utils
 import (
 	"encoding/json"
 	"github.com/labstack/gommon/log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// RWMap is a map safe for concurrent use that also serves its JSON
+// representation. The representation is built on demand by GetRepr from a
+// cached encoding of every entry, so a mutation only costs the encoding of the
+// entries it touched.
+//
+// Every mutation creates a new version of the map, the previous versions can
+// be read with View for as long as they are retained, see SetRetention.
 type RWMap[K comparable, V any] struct {
-	mLock sync.RWMutex
-	m     map[K]V
+	mLock   sync.RWMutex
+	m       map[K]revision[V]
+	version uint64
+	dirty   map[K]struct{}
 
-	reprLock    sync.RWMutex
+	// history holds the previous revisions of the keys changed by the
+	// retained commits, in version order
+	history   map[K][]revision[V]
+	commits   []commit[K]
+	floor     uint64
+	retention time.Duration
+	now       func() time.Time
+	onChange  func(version uint64, k K, old, v *V)
+	onPrune   func(floor uint64)
+
+	reprLock    sync.Mutex
 	repr        string
-	reprVersion int
+	reprVersion uint64
+	encoded     map[K]encodedEntry
+	reprHook    func(d time.Duration)
+}
+
+type encodedEntry struct {
+	key   string
+	value []byte
 }
 
 func NewRWMap[K comparable, V any]() *RWMap[K, V] {
-	return &RWMap[K, V]{m: map[K]V{}}
+	return &RWMap[K, V]{
+		m:       map[K]revision[V]{},
+		dirty:   map[K]struct{}{},
+		history: map[K][]revision[V]{},
+		repr:    "{}",
+		encoded: map[K]encodedEntry{},
+	}
 }
 
-func (m *RWMap[K, V]) GetRepr() string {
+// OnReprBuilt sets a function called with the time taken by every rebuild of
+// the JSON representation.
+func (m *RWMap[K, V]) OnReprBuilt(f func(d time.Duration)) {
+	m.reprLock.Lock()
+	m.reprHook = f
+	m.reprLock.Unlock()
+}
+
+// GetRepr returns the JSON representation of the latest version of the map,
+// with the keys sorted, and that version. Only the entries changed since the
+// last call are encoded again.
+func (m *RWMap[K, V]) GetRepr() (string, uint64) {
 	m.reprLock.Lock()
 	defer m.reprLock.Unlock()
-	return m.repr
+
+	m.mLock.Lock()
+	if m.version == m.reprVersion {
+		m.mLock.Unlock()
+		return m.repr, m.reprVersion
+	}
+	version, dirty := m.version, m.dirty
+	if len(dirty) == 0 {
+		// only versions that changed no key were created
+		m.reprVersion = version
+		m.mLock.Unlock()
+		return m.repr, m.reprVersion
+	}
+	m.dirty = map[K]struct{}{}
+	snapshot := make(map[K]V, len(dirty))
+	for k := range dirty {
+		if r, ok := m.m[k]; ok {
+			snapshot[k] = r.value
+		}
+	}
+	m.mLock.Unlock()
+
+	// the map is no longer locked, the entries are encoded from the snapshot
+	start := time.Now()
+	for k := range dirty {
+		delete(m.encoded, k)
+	}
+	for k, v := range snapshot {
+		e, err := encodeEntry(k, v)
+		if err != nil {
+			log.Warnf("marshal error: %v", err)
+			continue
+		}
+		m.encoded[k] = e
+	}
+
+	entries := make([]encodedEntry, 0, len(m.encoded))
+	size := 2
+	for _, e := range m.encoded {
+		entries = append(entries, e)
+		size += len(e.key) + len(e.value) + 2
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	var b strings.Builder
+	b.Grow(size)
+	b.WriteByte('{')
+	for i, e := range entries {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(e.key)
+		b.WriteByte(':')
+		b.Write(e.value)
+	}
+	b.WriteByte('}')
+
+	m.repr = b.String()
+	m.reprVersion = version
+	if m.reprHook != nil {
+		m.reprHook(time.Since(start))
+	}
+	return m.repr, m.reprVersion
+}
+
+func encodeEntry[K comparable, V any](k K, v V) (encodedEntry, error) {
+	key, err := json.Marshal(k)
+	if err != nil {
+		return encodedEntry{}, err
+	}
+	if key[0] != '"' {
+		// like encoding/json, non-string keys are quoted
+		key = []byte(`"` + string(key) + `"`)
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		return encodedEntry{}, err
+	}
+	return encodedEntry{key: string(key), value: value}, nil
+}
+
+// OnChange sets a function called, with the map locked, every time a key is
+// set or deleted at version. old is nil for a new key and v is nil for a
+// deleted one. It lets an index be kept consistent with every View.
+func (m *RWMap[K, V]) OnChange(f func(version uint64, k K, old, v *V)) {
+	m.mLock.Lock()
+	m.onChange = f
+	m.mLock.Unlock()
+}
+
+// OnPrune sets a function called, with the map locked, when the versions
+// before floor are no longer retained, so that an index can drop them too.
+func (m *RWMap[K, V]) OnPrune(f func(floor uint64)) {
+	m.mLock.Lock()
+	m.onPrune = f
+	m.mLock.Unlock()
+}
+
+// Commit calls f with the map locked and the version a mutation would
+// create, so that an index kept with OnChange can record a change of its own.
+// The version is only created if f returns true, no key changes in it.
+func (m *RWMap[K, V]) Commit(f func(version uint64) bool) {
+	m.mLock.Lock()
+	defer m.mLock.Unlock()
+
+	n := m.version + 1
+	if f(n) {
+		m.commit(n, nil)
+	}
 }
 
 func (m *RWMap[K, V]) Get(k K) V {
 	m.mLock.RLock()
-	val := m.m[k]
+	r := m.m[k]
 	m.mLock.RUnlock()
-	return val
+	return r.value
 }
 
 func (m *RWMap[K, V]) TryGet(k K) (V, bool) {
 	m.mLock.RLock()
-	val, ok := m.m[k]
+	r, ok := m.m[k]
 	m.mLock.RUnlock()
-	return val, ok
+	return r.value, ok
 }
 
 func (m *RWMap[K, V]) Set(k K, v V) {
 	m.mLock.Lock()
-	m.m[k] = v
-	m.updateRepr()
-	m.mLock.Unlock()
+	defer m.mLock.Unlock()
+
+	n := m.version + 1
+	m.commit(n, m.set(n, k, v))
 }
 
+// Delete removes k, it doesn't create a version if k is missing.
 func (m *RWMap[K, V]) Delete(k K) {
 	m.mLock.Lock()
-	delete(m.m, k)
-	m.updateRepr()
-	m.mLock.Unlock()
+	defer m.mLock.Unlock()
+
+	if _, ok := m.m[k]; !ok {
+		return
+	}
+	n := m.version + 1
+	m.commit(n, m.delete(n, k))
 }
 
-func (m *RWMap[K, V]) Update(k K, f func(v V) V) {
+// Move removes from and sets to in a single version, no version has both
+// keys or neither.
+func (m *RWMap[K, V]) Move(from, to K, v V) {
 	m.mLock.Lock()
-	m.m[k] = f(m.m[k])
-	m.updateRepr()
-	m.mLock.Unlock()
-}
+	defer m.mLock.Unlock()
 
-func (m *RWMap[K, V]) updateRepr() {
-	m.reprLock.Lock()
-	m.reprVersion++
-	go func(rv int, m1 map[K]V) {
-		data, err := json.Marshal(m1)
-		if err != nil {
-			log.Warnf("marshal error: %v", err)
-		}
-		m.reprLock.Lock()
-		if rv == m.reprVersion {
-			m.repr = string(data)
-		}
-		m.reprLock.Unlock()
-	}(m.reprVersion, copyMap(m.m))
-	m.reprLock.Unlock()
+	n := m.version + 1
+	var saved []K
+	if _, ok := m.m[from]; ok {
+		saved = m.delete(n, from)
+	}
+	m.commit(n, append(saved, m.set(n, to, v)...))
 }
 
-func (m *RWMap[K, V]) Copy() map[K]V {
+func (m *RWMap[K, V]) Update(k K, f func(v V) V) {
 	m.mLock.Lock()
-	res := copyMap(m.m)
-	m.mLock.Unlock()
-	return res
+	defer m.mLock.Unlock()
+
+	n := m.version + 1
+	m.commit(n, m.set(n, k, f(m.m[k].value)))
 }
 
-func copyMap[K comparable, V any](m map[K]V) map[K]V {
-	res := map[K]V{}
-	for k, v := range m {
-		res[k] = v
+func (m *RWMap[K, V]) Copy() map[K]V {
+	m.mLock.RLock()
+	defer m.mLock.RUnlock()
+
+	res := make(map[K]V, len(m.m))
+	for k, r := range m.m {
+		res[k] = r.value
 	}
 	return res
 }
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRWMapRepr(t *testing.T) {
+	m := NewRWMap[string, []int]()
+	builds := 0
+	m.OnReprBuilt(func(time.Duration) {
+		builds++
+	})
+
+	// the representation of an empty map is never the empty string
+	if repr, _ := m.GetRepr(); repr != "{}" {
+		t.Fatalf("got %q for an empty map", repr)
+	}
+
+	check := func(want map[string][]int) {
+		t.Helper()
+		repr, _ := m.GetRepr()
+		data, err := json.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repr != string(data) {
+			t.Fatalf("got %s, want %s", repr, data)
+		}
+	}
+
+	m.Set("b", []int{2})
+	m.Set("a", []int{1})
+	m.Set("c\"", nil)
+	check(map[string][]int{"a": {1}, "b": {2}, "c\"": nil})
+
+	m.Update("a", func(v []int) []int { return append(v, 10) })
+	m.Delete("b")
+	m.Delete("missing")
+	check(map[string][]int{"a": {1, 10}, "c\"": nil})
+
+	// the representation is only built again after a change
+	n := builds
+	check(map[string][]int{"a": {1, 10}, "c\"": nil})
+	if builds != n {
+		t.Fatalf("the representation was built again without changes")
+	}
+
+	ints := NewRWMap[int, string]()
+	ints.Set(2, "b")
+	ints.Set(1, "a")
+	if repr, _ := ints.GetRepr(); repr != `{"1":"a","2":"b"}` {
+		t.Fatalf("got %s for int keys", repr)
+	}
+}
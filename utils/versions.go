@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Latest asks View for the latest version of the map.
+const Latest = ^uint64(0)
+
+var (
+	ErrVersionExpired = errors.New("version no longer retained")
+	ErrVersionUnknown = errors.New("unknown version")
+)
+
+// revision is a value of a key from a version on. A deleted revision marks the
+// version the key was removed at.
+type revision[V any] struct {
+	version uint64
+	value   V
+	deleted bool
+}
+
+// commit records when a version was created and the keys it moved to the
+// history, they are trimmed once the versions before it are no longer
+// retained.
+type commit[K comparable] struct {
+	version uint64
+	time    time.Time
+	keys    []K
+}
+
+// SetRetention keeps every version for d after it is replaced by the next
+// one, times are taken from now. By default only the latest version is kept.
+// The expired versions can no longer be viewed, but their revisions are only
+// dropped by the next mutation or by Prune.
+func (m *RWMap[K, V]) SetRetention(d time.Duration, now func() time.Time) {
+	m.mLock.Lock()
+	defer m.mLock.Unlock()
+
+	m.retention = d
+	m.now = now
+	m.prune()
+}
+
+// Prune drops the revisions of the versions that are no longer retained, for
+// maps that may not be mutated for a while.
+func (m *RWMap[K, V]) Prune() {
+	m.mLock.RLock()
+	expired := m.expired()
+	m.mLock.RUnlock()
+	if expired == 0 {
+		return
+	}
+
+	m.mLock.Lock()
+	defer m.mLock.Unlock()
+	m.prune()
+}
+
+// Version returns the latest version of the map, 0 being the empty map it
+// starts from.
+func (m *RWMap[K, V]) Version() uint64 {
+	m.mLock.RLock()
+	defer m.mLock.RUnlock()
+	return m.version
+}
+
+// View calls f with a read-only view of the map at version v, or at the
+// latest version if v is Latest, and returns that version. The map is locked
+// for reading while f runs.
+func (m *RWMap[K, V]) View(v uint64, f func(view View[K, V])) (uint64, error) {
+	m.mLock.RLock()
+	defer m.mLock.RUnlock()
+
+	if v == Latest {
+		v = m.version
+	}
+	if v > m.version {
+		return 0, ErrVersionUnknown
+	}
+	floor := m.floor
+	if n := m.expired(); n > 0 {
+		// not pruned yet
+		floor = m.commits[n-1].version
+	}
+	if v < floor {
+		return 0, ErrVersionExpired
+	}
+	f(View[K, V]{m: m, version: v})
+	return v, nil
+}
+
+// View is a version of a RWMap, only valid in the function passed to
+// RWMap.View.
+type View[K comparable, V any] struct {
+	m       *RWMap[K, V]
+	version uint64
+}
+
+func (v View[K, V]) Version() uint64 {
+	return v.version
+}
+
+// IsLatest reports whether the view is of the latest version.
+func (v View[K, V]) IsLatest() bool {
+	return v.version == v.m.version
+}
+
+func (v View[K, V]) TryGet(k K) (V, bool) {
+	if r, ok := v.m.m[k]; ok && r.version <= v.version {
+		return r.value, true
+	}
+	return revisionAt(v.m.history[k], v.version)
+}
+
+// Range calls f for every key of the view, in no particular order, until f
+// returns false.
+func (v View[K, V]) Range(f func(k K, val V) bool) {
+	for k, r := range v.m.m {
+		val, ok := r.value, true
+		if r.version > v.version {
+			val, ok = revisionAt(v.m.history[k], v.version)
+		}
+		if ok && !f(k, val) {
+			return
+		}
+	}
+	if v.IsLatest() {
+		return
+	}
+	// the keys removed since the version
+	for k, revs := range v.m.history {
+		if _, ok := v.m.m[k]; ok {
+			continue
+		}
+		if val, ok := revisionAt(revs, v.version); ok && !f(k, val) {
+			return
+		}
+	}
+}
+
+// revisionAt returns the value of the last revision at or before version.
+func revisionAt[V any](revs []revision[V], version uint64) (V, bool) {
+	i := sort.Search(len(revs), func(i int) bool { return revs[i].version > version })
+	if i == 0 || revs[i-1].deleted {
+		var zero V
+		return zero, false
+	}
+	return revs[i-1].value, true
+}
+
+// set stores v as the revision of k at version n, and returns the keys moved
+// to the history. m.mLock must be held.
+func (m *RWMap[K, V]) set(n uint64, k K, v V) []K {
+	old, ok := m.m[k]
+	m.m[k] = revision[V]{version: n, value: v}
+	m.dirty[k] = struct{}{}
+	if m.onChange != nil {
+		if ok {
+			m.onChange(n, k, &old.value, &v)
+		} else {
+			m.onChange(n, k, nil, &v)
+		}
+	}
+	if !ok {
+		return nil
+	}
+	m.history[k] = append(m.history[k], old)
+	return []K{k}
+}
+
+// delete removes k at version n, and returns the keys moved to the history.
+// m.mLock must be held.
+func (m *RWMap[K, V]) delete(n uint64, k K) []K {
+	old := m.m[k]
+	delete(m.m, k)
+	m.dirty[k] = struct{}{}
+	if m.onChange != nil {
+		m.onChange(n, k, &old.value, nil)
+	}
+	m.history[k] = append(m.history[k], old, revision[V]{version: n, deleted: true})
+	return []K{k}
+}
+
+// commit makes n the latest version. m.mLock must be held.
+func (m *RWMap[K, V]) commit(n uint64, saved []K) {
+	m.version = n
+	c := commit[K]{version: n, keys: saved}
+	if m.retention > 0 && m.now != nil {
+		c.time = m.now()
+	}
+	m.commits = append(m.commits, c)
+	m.prune()
+}
+
+// expired returns how many of the commits replaced a version that is no
+// longer retained. A version is retained while it is the latest, and for the
+// retention after the next commit. m.mLock must be held.
+func (m *RWMap[K, V]) expired() int {
+	if m.retention <= 0 || m.now == nil {
+		return len(m.commits)
+	}
+	deadline := m.now().Add(-m.retention)
+	return sort.Search(len(m.commits), func(i int) bool {
+		return m.commits[i].time.After(deadline)
+	})
+}
+
+// prune drops the versions that are no longer retained. m.mLock must be held.
+func (m *RWMap[K, V]) prune() {
+	n := m.expired()
+	for _, c := range m.commits[:n] {
+		m.floor = c.version
+		for _, k := range c.keys {
+			m.trim(k)
+		}
+	}
+	if n > 0 {
+		m.commits = append(m.commits[:0:0], m.commits[n:]...)
+		if m.onPrune != nil {
+			m.onPrune(m.floor)
+		}
+	}
+}
+
+// trim drops the revisions of k that no retained version can see.
+func (m *RWMap[K, V]) trim(k K) {
+	revs := m.history[k]
+	if r, ok := m.m[k]; ok && r.version <= m.floor {
+		delete(m.history, k)
+		return
+	}
+
+	// keep the revision seen by the oldest retained version, unless it only
+	// says the key is missing
+	i := sort.Search(len(revs), func(i int) bool { return revs[i].version > m.floor })
+	if i > 0 && !revs[i-1].deleted {
+		i--
+	}
+	if i == len(revs) {
+		delete(m.history, k)
+	} else if i > 0 {
+		m.history[k] = append(revs[:0:0], revs[i:]...)
+	}
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRWMapVersions(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewRWMap[string, int]()
+	m.SetRetention(time.Minute, func() time.Time { return now })
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("a", 10)
+	m.Move("b", "c", 2)
+	m.Delete("a")
+	if v := m.Version(); v != 5 {
+		t.Fatalf("got version %d, want 5", v)
+	}
+
+	check := func(version uint64, want map[string]int) {
+		t.Helper()
+		got := map[string]int{}
+		v, err := m.View(version, func(view View[string, int]) {
+			view.Range(func(k string, val int) bool {
+				got[k] = val
+				return true
+			})
+			for k, val := range want {
+				if x, ok := view.TryGet(k); !ok || x != val {
+					t.Fatalf("version %d: got %s=%d, want %d", version, k, x, val)
+				}
+			}
+		})
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if version != Latest && v != version {
+			t.Fatalf("viewed version %d, want %d", v, version)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("version %d: got %v, want %v", version, got, want)
+		}
+		for k, val := range want {
+			if got[k] != val {
+				t.Fatalf("version %d: got %v, want %v", version, got, want)
+			}
+		}
+	}
+	check(0, map[string]int{})
+	check(1, map[string]int{"a": 1})
+	check(2, map[string]int{"a": 1, "b": 2})
+	check(3, map[string]int{"a": 10, "b": 2})
+	check(4, map[string]int{"a": 10, "c": 2})
+	check(5, map[string]int{"c": 2})
+	check(Latest, map[string]int{"c": 2})
+
+	if _, err := m.View(6, func(View[string, int]) {}); !errors.Is(err, ErrVersionUnknown) {
+		t.Fatalf("got %v for a future version, want ErrVersionUnknown", err)
+	}
+
+	// the versions replaced more than a minute ago are dropped on the next
+	// commit, the ones replaced since are kept
+	now = now.Add(30 * time.Second)
+	m.Set("d", 4)
+	now = now.Add(31 * time.Second)
+	m.Set("c", 3)
+	for v := uint64(0); v < 5; v++ {
+		if _, err := m.View(v, func(View[string, int]) {}); !errors.Is(err, ErrVersionExpired) {
+			t.Fatalf("got %v for version %d, want ErrVersionExpired", err, v)
+		}
+	}
+	check(5, map[string]int{"c": 2})
+	check(6, map[string]int{"c": 2, "d": 4})
+	check(7, map[string]int{"c": 3, "d": 4})
+
+	// without retention only the latest version is kept
+	m.SetRetention(0, nil)
+	if _, err := m.View(6, func(View[string, int]) {}); !errors.Is(err, ErrVersionExpired) {
+		t.Fatalf("got %v for version 6, want ErrVersionExpired", err)
+	}
+	if len(m.history) != 0 {
+		t.Fatalf("history not trimmed: %v", m.history)
+	}
+	check(7, map[string]int{"c": 3, "d": 4})
+}
+
+func TestRWMapIdleRetention(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewRWMap[string, int]()
+	m.SetRetention(time.Minute, func() time.Time { return now })
+
+	m.Set("a", 1)
+	m.Set("a", 2)
+	if _, err := m.View(1, func(View[string, int]) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without any change, the old versions expire with the time
+	now = now.Add(time.Minute + time.Second)
+	if _, err := m.View(1, func(View[string, int]) {}); !errors.Is(err, ErrVersionExpired) {
+		t.Fatalf("got %v for version 1, want ErrVersionExpired", err)
+	}
+	if len(m.history) == 0 {
+		t.Fatal("history trimmed without a change or a prune")
+	}
+	m.Prune()
+	if len(m.history) != 0 || len(m.commits) != 0 {
+		t.Fatalf("history not trimmed: %v", m.history)
+	}
+	if v, err := m.View(2, func(view View[string, int]) {
+		if x, _ := view.TryGet("a"); x != 2 {
+			t.Fatalf("got a=%d, want 2", x)
+		}
+	}); err != nil || v != 2 {
+		t.Fatalf("got version %d and %v for the latest version", v, err)
+	}
+}
+
+func TestRWMapCommit(t *testing.T) {
+	m := NewRWMap[string, int]()
+	m.Set("a", 1)
+	if repr, v := m.GetRepr(); repr != `{"a":1}` || v != 1 {
+		t.Fatalf("got %s at version %d", repr, v)
+	}
+
+	var committed uint64
+	m.Commit(func(version uint64) bool {
+		committed = version
+		return true
+	})
+	m.Commit(func(uint64) bool { return false })
+	if v := m.Version(); committed != 2 || v != 2 {
+		t.Fatalf("committed version %d, latest %d, want 2", committed, v)
+	}
+	if repr, v := m.GetRepr(); repr != `{"a":1}` || v != 2 {
+		t.Fatalf("got %s at version %d", repr, v)
+	}
+	if _, err := m.View(2, func(view View[string, int]) {
+		if x, _ := view.TryGet("a"); x != 1 {
+			t.Fatalf("got a=%d at version 2", x)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
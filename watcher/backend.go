@@ -0,0 +1,38 @@
+package watcher
+
+import (
+	"github.com/fsnotify/fsnotify"
+)
+
+// Backend is a non-recursive source of file system events, RWatcher builds
+// recursive watches on top of it. Implementations report events with the same
+// semantics as fsnotify: a move within the watched tree is a Rename of the old
+// name immediately followed by a Create of the new one.
+type Backend interface {
+	Add(name string) error
+	Remove(name string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+type fsnotifyBackend struct {
+	*fsnotify.Watcher
+}
+
+// NewFsnotifyBackend returns a Backend using the native OS notifications.
+func NewFsnotifyBackend() (Backend, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return fsnotifyBackend{w}, nil
+}
+
+func (b fsnotifyBackend) Events() <-chan fsnotify.Event {
+	return b.Watcher.Events
+}
+
+func (b fsnotifyBackend) Errors() <-chan error {
+	return b.Watcher.Errors
+}
@@ -0,0 +1,220 @@
+package watcher
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPollInterval = 10 * time.Millisecond
+
+// backendCases are run against every backend, which must report the same
+// events for them.
+var backendCases = []struct {
+	name string
+	// setup runs before the watches are added, on dir and on the
+	// directories listed in watch
+	setup  func(t *testing.T, dir string)
+	watch  []string
+	action func(t *testing.T, dir string)
+	want   []string
+	// renames needs file ids to pair the two names
+	renames bool
+}{
+	{
+		name:   "create",
+		action: func(t *testing.T, dir string) { writeFile(t, dir, "a.md", "a") },
+		want:   []string{"CREATE a.md"},
+	},
+	{
+		name:   "write",
+		setup:  func(t *testing.T, dir string) { writeFile(t, dir, "a.md", "a") },
+		action: func(t *testing.T, dir string) { writeFile(t, dir, "a.md", "a longer") },
+		want:   []string{"WRITE a.md"},
+	},
+	{
+		name:   "remove",
+		setup:  func(t *testing.T, dir string) { writeFile(t, dir, "a.md", "a") },
+		action: func(t *testing.T, dir string) { remove(t, dir, "a.md") },
+		want:   []string{"REMOVE a.md"},
+	},
+	{
+		name:    "rename",
+		setup:   func(t *testing.T, dir string) { writeFile(t, dir, "a.md", "a") },
+		action:  func(t *testing.T, dir string) { rename(t, dir, "a.md", "b.md") },
+		want:    []string{"RENAME a.md", "CREATE b.md"},
+		renames: true,
+	},
+	{
+		name:   "create dir",
+		action: func(t *testing.T, dir string) { mkdir(t, dir, "sub") },
+		want:   []string{"CREATE sub"},
+	},
+	{
+		name: "move dir",
+		setup: func(t *testing.T, dir string) {
+			writeFile(t, dir, "sub/a.md", "a")
+		},
+		watch:   []string{"sub"},
+		action:  func(t *testing.T, dir string) { rename(t, dir, "sub", "moved") },
+		want:    []string{"RENAME sub", "CREATE moved", "RENAME sub"},
+		renames: true,
+	},
+	{
+		name: "remove dir",
+		setup: func(t *testing.T, dir string) {
+			writeFile(t, dir, "sub/a.md", "a")
+		},
+		watch:  []string{"sub"},
+		action: func(t *testing.T, dir string) { remove(t, dir, "sub") },
+		want:   []string{"REMOVE sub/a.md", "REMOVE sub", "REMOVE sub"},
+	},
+	{
+		name:  "file replaced by a dir",
+		setup: func(t *testing.T, dir string) { writeFile(t, dir, "a", "a") },
+		action: func(t *testing.T, dir string) {
+			remove(t, dir, "a")
+			mkdir(t, dir, "a")
+		},
+		want: []string{"REMOVE a", "CREATE a"},
+	},
+}
+
+func TestBackends(t *testing.T) {
+	backends := []struct {
+		name string
+		new  func() (Backend, error)
+	}{
+		{"fsnotify", NewFsnotifyBackend},
+		{"poll", func() (Backend, error) { return NewPollBackend(testPollInterval) }},
+	}
+
+	for _, backend := range backends {
+		for _, tc := range backendCases {
+			backend, tc := backend, tc
+			t.Run(backend.name+"/"+tc.name, func(t *testing.T) {
+				dir := t.TempDir()
+				if tc.renames && !hasFileIDs(t, dir) {
+					t.Skip("file ids are not available")
+				}
+				if tc.setup != nil {
+					tc.setup(t, dir)
+				}
+
+				b, err := backend.new()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer b.Close()
+				for _, w := range append([]string{""}, tc.watch...) {
+					if err := b.Add(filepath.Join(dir, w)); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				tc.action(t, dir)
+				got := strings.Join(collectEvents(t, b, dir), ", ")
+				if want := strings.Join(tc.want, ", "); got != want {
+					t.Fatalf("got events %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestPollBackendClose(t *testing.T) {
+	b, err := NewPollBackend(testPollInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-b.Events(); ok {
+		t.Fatal("events still open after Close")
+	}
+	if err := b.Add(t.TempDir()); err != ErrClosed {
+		t.Fatalf("Add after Close: got %v, want ErrClosed", err)
+	}
+	if _, err := NewPollBackend(0); err == nil {
+		t.Fatal("a zero interval is accepted")
+	}
+}
+
+// collectEvents returns the events received until none came for a while, with
+// their names relative to dir. A file written right after being created is
+// reported once by the polling backend and several times by fsnotify, so the
+// writes that follow a create or a write of the same file are left out, and
+// so are the chmods.
+func collectEvents(t *testing.T, b Backend, dir string) []string {
+	t.Helper()
+
+	var res []string
+	var last fsnotify.Event
+	for {
+		select {
+		case e := <-b.Events():
+			if e.Op == fsnotify.Chmod {
+				continue
+			}
+			if e.Op == fsnotify.Write && e.Name == last.Name && last.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+				continue
+			}
+			last = e
+			rel, err := filepath.Rel(dir, e.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, e.Op.String()+" "+rel)
+
+		case err := <-b.Errors():
+			t.Fatal(err)
+
+		case <-time.After(20 * testPollInterval):
+			return res
+		}
+	}
+}
+
+func hasFileIDs(t *testing.T, dir string) bool {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileID(fi) != 0
+}
+
+func writeFile(t *testing.T, dir, name, contents string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mkdir(t *testing.T, dir, name string) {
+	t.Helper()
+	if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func remove(t *testing.T, dir, name string) {
+	t.Helper()
+	if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func rename(t *testing.T, dir, oldName, newName string) {
+	t.Helper()
+	if err := os.Rename(filepath.Join(dir, oldName), filepath.Join(dir, newName)); err != nil {
+		t.Fatal(err)
+	}
+}
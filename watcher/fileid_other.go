@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package watcher
+
+import (
+	"os"
+)
+
+// fileID is not available from os.FileInfo here, renames are then reported as
+// a Remove and a Create.
+func fileID(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+
+package watcher
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileID(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
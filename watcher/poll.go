@@ -0,0 +1,299 @@
+package watcher
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pollStat is what the polling backend remembers of a file to detect changes.
+type pollStat struct {
+	isDir   bool
+	size    int64
+	modTime time.Time
+	id      uint64
+}
+
+type pollBackend struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+
+	lock sync.Mutex
+	// watched maps each watched path to the stat of its entries, a watched
+	// file maps to a single entry for itself
+	watched  map[string]map[string]pollStat
+	isClosed bool
+}
+
+// NewPollBackend returns a Backend that stats the watched directories at every
+// interval. It works on file systems without change notifications, like
+// network mounts and FUSE, at the cost of latency and I/O.
+func NewPollBackend(interval time.Duration) (Backend, error) {
+	if interval <= 0 {
+		return nil, errors.New("poll interval must be positive")
+	}
+
+	b := &pollBackend{
+		interval: interval,
+		events:   make(chan fsnotify.Event, 64),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		watched:  map[string]map[string]pollStat{},
+	}
+	go b.start()
+	return b, nil
+}
+
+func (b *pollBackend) Add(name string) error {
+	name = filepath.Clean(name)
+	snapshot, err := pollSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.isClosed {
+		return ErrClosed
+	}
+	if _, ok := b.watched[name]; !ok {
+		b.watched[name] = snapshot
+	}
+	return nil
+}
+
+func (b *pollBackend) Remove(name string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	name = filepath.Clean(name)
+	if _, ok := b.watched[name]; !ok {
+		return errors.New("can't remove non-existent poll watch for: " + name)
+	}
+	delete(b.watched, name)
+	return nil
+}
+
+func (b *pollBackend) Events() <-chan fsnotify.Event {
+	return b.events
+}
+
+func (b *pollBackend) Errors() <-chan error {
+	return b.errors
+}
+
+func (b *pollBackend) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.isClosed {
+		return nil
+	}
+	b.isClosed = true
+	close(b.done)
+	return nil
+}
+
+func (b *pollBackend) start() {
+	ticker := time.NewTicker(b.interval)
+	defer func() {
+		ticker.Stop()
+		close(b.events)
+		close(b.errors)
+	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, e := range b.poll() {
+				select {
+				case b.events <- e:
+				case <-b.done:
+					return
+				}
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// poll compares every watched path with its last snapshot. Entries that
+// disappeared from one place and appeared in another with the same file id
+// are reported as a Rename followed by a Create, like fsnotify does.
+// A watched path that is gone reports itself too. When it was moved with its
+// parent, it is renamed after the parent's events and its contents are not
+// reported, like fsnotify does. Otherwise the removals of its last known
+// entries come first, then its own, the deepest directories first.
+func (b *pollBackend) poll() []fsnotify.Event {
+	b.lock.Lock()
+	names := make([]string, 0, len(b.watched))
+	for name := range b.watched {
+		names = append(names, name)
+	}
+	b.lock.Unlock()
+	sort.Strings(names)
+
+	type entry struct {
+		name string
+		stat pollStat
+	}
+	type goneEntry struct {
+		name    string
+		entries map[string]pollStat
+	}
+	var created, removed []entry
+	var events []fsnotify.Event
+	var gone []goneEntry
+
+	for _, name := range names {
+		snapshot, err := pollSnapshot(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			b.lock.Lock()
+			old, ok := b.watched[name]
+			delete(b.watched, name)
+			b.lock.Unlock()
+			if ok {
+				gone = append(gone, goneEntry{name, old})
+			}
+			continue
+		} else if err != nil {
+			continue
+		}
+
+		b.lock.Lock()
+		old, ok := b.watched[name]
+		if ok {
+			b.watched[name] = snapshot
+		}
+		b.lock.Unlock()
+		if !ok {
+			continue
+		}
+
+		paths := make([]string, 0, len(snapshot))
+		for p := range snapshot {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+
+		for _, p := range paths {
+			cur := snapshot[p]
+			prev, existed := old[p]
+			switch {
+			case !existed || prev.isDir != cur.isDir || (cur.id != 0 && prev.id != cur.id):
+				if existed {
+					removed = append(removed, entry{p, prev})
+				}
+				created = append(created, entry{p, cur})
+			case !cur.isDir && (prev.size != cur.size || !prev.modTime.Equal(cur.modTime)):
+				events = append(events, fsnotify.Event{Name: p, Op: fsnotify.Write})
+			}
+		}
+		for p, prev := range old {
+			if _, ok := snapshot[p]; !ok {
+				removed = append(removed, entry{p, prev})
+			}
+		}
+	}
+
+	paired, moved := map[string]bool{}, map[string]bool{}
+	for _, r := range removed {
+		var to string
+		if r.stat.id != 0 {
+			for _, c := range created {
+				if c.stat.id == r.stat.id && !paired[c.name] && c.name != r.name {
+					to = c.name
+					break
+				}
+			}
+		}
+		if to == "" {
+			events = append(events, fsnotify.Event{Name: r.name, Op: fsnotify.Remove})
+			continue
+		}
+		paired[to], moved[r.name] = true, true
+		events = append(events,
+			fsnotify.Event{Name: r.name, Op: fsnotify.Rename},
+			fsnotify.Event{Name: to, Op: fsnotify.Create})
+	}
+	for _, c := range created {
+		if !paired[c.name] {
+			events = append(events, fsnotify.Event{Name: c.name, Op: fsnotify.Create})
+		}
+	}
+
+	movedWith := func(name string) bool {
+		for ; ; name = filepath.Dir(name) {
+			if moved[name] {
+				return true
+			}
+			if parent := filepath.Dir(name); parent == name {
+				return false
+			}
+		}
+	}
+	var goneEvents []fsnotify.Event
+	for i := len(gone) - 1; i >= 0; i-- {
+		g := gone[i]
+		if movedWith(g.name) {
+			events = append(events, fsnotify.Event{Name: g.name, Op: fsnotify.Rename})
+			continue
+		}
+		paths := make([]string, 0, len(g.entries))
+		for p := range g.entries {
+			if p != g.name {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			goneEvents = append(goneEvents, fsnotify.Event{Name: p, Op: fsnotify.Remove})
+		}
+		goneEvents = append(goneEvents, fsnotify.Event{Name: g.name, Op: fsnotify.Remove})
+	}
+	return append(goneEvents, events...)
+}
+
+// pollSnapshot stats the entries of a directory, or the file itself.
+func pollSnapshot(name string) (map[string]pollStat, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return map[string]pollStat{name: newPollStat(fi)}, nil
+	}
+
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]pollStat, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			// removed since ReadDir
+			continue
+		}
+		res[filepath.Join(name, e.Name())] = newPollStat(info)
+	}
+	return res, nil
+}
+
+func newPollStat(fi os.FileInfo) pollStat {
+	return pollStat{
+		isDir:   fi.IsDir(),
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		id:      fileID(fi),
+	}
+}
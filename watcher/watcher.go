@@ -5,6 +5,8 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 const (
@@ -15,78 +17,120 @@ const (
 	Chmod  = fsnotify.Chmod
 )
 
-// RWatcher wraps fsnotify.Watcher. When fsnotify adds recursive watches, you should be able to switch your code to use fsnotify.Watcher
+var ErrClosed = errors.New("rfsnotify instance already closed")
+
+// RWatcher wraps a Backend, fsnotify.Watcher by default. When fsnotify adds recursive watches, you should be able to switch your code to use fsnotify.Watcher
 type RWatcher struct {
 	Events chan fsnotify.Event
 	Errors chan error
 
-	filter   func(walkPath string, d os.DirEntry) bool
-	done     chan struct{}
-	fsnotify *fsnotify.Watcher
+	filter  func(walkPath string, d os.DirEntry) bool
+	done    chan struct{}
+	backend Backend
+
+	lock     sync.Mutex
 	isClosed bool
+	watched  map[string]struct{}
 }
 
 // NewWatcher establishes a new watcher with the underlying OS and begins waiting for events.
 func NewWatcher() (*RWatcher, error) {
-	fsWatch, err := fsnotify.NewWatcher()
+	return NewWatcherWithFilter(nil)
+}
+
+// NewWatcherWithFilter is like NewWatcher, but only the directories and files
+// for which filter returns true are watched and reported when scanning.
+func NewWatcherWithFilter(filter func(walkPath string, d os.DirEntry) bool) (*RWatcher, error) {
+	backend, err := NewFsnotifyBackend()
 	if err != nil {
 		return nil, err
 	}
+	return NewWatcherWithBackend(backend, filter), nil
+}
 
+// NewWatcherWithBackend is like NewWatcherWithFilter, using the given backend
+// to receive the events. filter may be nil.
+func NewWatcherWithBackend(backend Backend, filter func(walkPath string, d os.DirEntry) bool) *RWatcher {
 	m := &RWatcher{}
-	m.fsnotify = fsWatch
+	m.backend = backend
+	m.filter = filter
 	m.Events = make(chan fsnotify.Event)
 	m.Errors = make(chan error)
 	m.done = make(chan struct{})
+	m.watched = map[string]struct{}{}
 
 	go m.start()
 
-	return m, nil
-}
-
-func NewWatcherWithFilter(filter func(walkPath string, d os.DirEntry) bool) (*RWatcher, error) {
-	m, err := NewWatcher()
-	if err != nil {
-		return nil, err
-	}
-	m.filter = filter
-	return m, nil
+	return m
 }
 
 // Add starts watching the named file or directory (non-recursively).
 func (m *RWatcher) Add(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	if m.isClosed {
-		return errors.New("rfsnotify instance already closed")
+		return ErrClosed
 	}
-	return m.fsnotify.Add(name)
+	return m.add(name)
 }
 
 // AddRecursive starts watching the named directory and all sub-directories.
 func (m *RWatcher) AddRecursive(name string) error {
-	if m.isClosed {
-		return errors.New("rfsnotify instance already closed")
-	}
-	if err := m.watchRecursive(name, false); err != nil {
-		return err
-	}
-	return nil
+	_, err := m.watchRecursive(name, false)
+	return err
 }
 
 // Remove stops watching the the named file or directory (non-recursively).
 func (m *RWatcher) Remove(name string) error {
-	return m.fsnotify.Remove(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	name = filepath.Clean(name)
+	delete(m.watched, name)
+	return m.backend.Remove(name)
 }
 
 // RemoveRecursive stops watching the named directory and all sub-directories.
+// It works from the list of watched directories, so it can be used after the
+// directory has been removed or renamed.
 func (m *RWatcher) RemoveRecursive(name string) error {
-	if err := m.watchRecursive(name, true); err != nil {
-		return err
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	name = filepath.Clean(name)
+	if _, ok := m.watched[name]; !ok {
+		// the sub-directories of a directory that is not watched are not
+		// either, this is most files
+		return nil
+	}
+	for w := range m.watched {
+		if w == name || strings.HasPrefix(w, name+string(filepath.Separator)) {
+			delete(m.watched, w)
+			// the OS drops the watch by itself when the directory is removed
+			_ = m.backend.Remove(w)
+		}
 	}
 	return nil
 }
 
+// WatchList returns the watched files and directories.
+func (m *RWatcher) WatchList() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	res := make([]string, 0, len(m.watched))
+	for w := range m.watched {
+		res = append(res, w)
+	}
+	return res
+}
+
 // Close removes all watches and closes the events channel.
 func (m *RWatcher) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	if m.isClosed {
 		return nil
 	}
@@ -95,57 +139,122 @@ func (m *RWatcher) Close() error {
 	return nil
 }
 
+func (m *RWatcher) add(name string) error {
+	name = filepath.Clean(name)
+	if err := m.backend.Add(name); err != nil {
+		return err
+	}
+	m.watched[name] = struct{}{}
+	return nil
+}
+
 func (m *RWatcher) start() {
+	defer func() {
+		_ = m.backend.Close()
+		close(m.Events)
+		close(m.Errors)
+	}()
+
 	for {
 		select {
 
-		case e := <-m.fsnotify.Events:
+		case e, ok := <-m.backend.Events():
+			if !ok {
+				return
+			}
+			var created []fsnotify.Event
 			s, err := os.Stat(e.Name)
 			if err == nil && s != nil && s.IsDir() {
 				if e.Op&fsnotify.Create != 0 {
-					m.watchRecursive(e.Name, false)
+					// files created before the watch was added would go unnoticed
+					created, err = m.watchRecursive(e.Name, true)
+					if err != nil && !m.send(nil, err) {
+						return
+					}
 				}
 			}
 			//Can't stat a deleted directory, so just pretend that it's always a directory and
 			//try to remove from the watch list...  we really have no clue if it's a directory or not...
-			if e.Op&fsnotify.Remove != 0 {
-				m.fsnotify.Remove(e.Name)
+			if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				_ = m.RemoveRecursive(e.Name)
+			}
+			if !m.send(&e, nil) {
+				return
+			}
+			for i := range created {
+				if !m.send(&created[i], nil) {
+					return
+				}
 			}
-			m.Events <- e
 
-		case e := <-m.fsnotify.Errors:
-			m.Errors <- e
+		case e, ok := <-m.backend.Errors():
+			if !ok {
+				return
+			}
+			if !m.send(nil, e) {
+				return
+			}
 
 		case <-m.done:
-			m.fsnotify.Close()
-			close(m.Events)
-			close(m.Errors)
 			return
 		}
 	}
 }
 
+// send delivers an event or an error, it returns false if the watcher was
+// closed while waiting for the receiver.
+func (m *RWatcher) send(e *fsnotify.Event, err error) bool {
+	if e != nil {
+		select {
+		case m.Events <- *e:
+			return true
+		case <-m.done:
+			return false
+		}
+	}
+	select {
+	case m.Errors <- err:
+		return true
+	case <-m.done:
+		return false
+	}
+}
+
 // watchRecursive adds all directories under the given one to the watch list.
-// this is probably a very racey process. What if a file is added to a folder before we get the watch added?
-func (m *RWatcher) watchRecursive(path string, unWatch bool) error {
+// When scan is set, it also returns a Create event for everything found
+// under path, since it may have been created before the watch was added.
+func (m *RWatcher) watchRecursive(path string, scan bool) ([]fsnotify.Event, error) {
+	var created []fsnotify.Event
 	err := filepath.WalkDir(path, func(walkPath string, d os.DirEntry, err error) error {
 		if err != nil {
+			// a directory removed meanwhile needs no watch
+			if errors.Is(err, os.ErrNotExist) && walkPath != path {
+				return nil
+			}
 			return err
 		}
+		if m.filter != nil && !m.filter(walkPath, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if scan && walkPath != path {
+			created = append(created, fsnotify.Event{Name: walkPath, Op: fsnotify.Create})
+		}
 		if d.IsDir() {
-			if unWatch {
-				if err = m.fsnotify.Remove(walkPath); err != nil {
-					return err
-				}
-			} else if m.filter == nil || m.filter(walkPath, d) {
-				if err = m.fsnotify.Add(walkPath); err != nil {
-					return err
-				}
-			} else {
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			if m.isClosed {
+				return ErrClosed
+			}
+			if err = m.add(walkPath); errors.Is(err, os.ErrNotExist) && walkPath != path {
 				return filepath.SkipDir
+			} else if err != nil {
+				return err
 			}
 		}
 		return nil
 	})
-	return err
+	return created, err
 }
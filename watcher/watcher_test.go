@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRemoveRecursive(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a/b/c.md", "c")
+	writeFile(t, dir, "a2/d.md", "d")
+
+	w, err := NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.AddRecursive(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	watched := func() string {
+		var res []string
+		for _, name := range w.WatchList() {
+			rel, _ := filepath.Rel(dir, name)
+			res = append(res, filepath.ToSlash(rel))
+		}
+		sort.Strings(res)
+		return strings.Join(res, " ")
+	}
+	if got := watched(); got != ". a a/b a2" {
+		t.Fatalf("watching %q", got)
+	}
+
+	// a file is not watched and removes nothing, a directory removes the
+	// directories under it only
+	if err := w.RemoveRecursive(filepath.Join(dir, "a/b/c.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RemoveRecursive(filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if got := watched(); got != ". a2" {
+		t.Fatalf("watching %q after the removals", got)
+	}
+}